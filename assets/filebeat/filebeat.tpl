@@ -1,27 +1,69 @@
 {{range .configList}}
-- type: log
+- type: {{ $.inputType }}
+  {{- if eq $.inputType "filestream" }}
+  id: {{ quote (printf "%s-%s" $.containerId .Name) }}
+  {{- end }}
   enabled: true
   paths:
-      - {{ .LogFile }}
+      - {{ quote .LogFile }}
+  {{- if eq $.inputType "filestream" }}
+  prospector.scanner.check_interval: 10s
+  {{- else }}
   scan_frequency: 10s
+  {{- end }}
   fields_under_root: true
-  {{if .Stdout}}
+  {{- if eq $.inputType "filestream" }}
+  {{- if or .Stdout (eq .Format "json") }}
+  parsers:
+    {{- if .Stdout }}
+    - container:
+        stream: all
+        format: docker
+    {{- end }}
+    {{- if eq .Format "json" }}
+    - ndjson:
+        target: ""
+    {{- end }}
+  {{- end }}
+  {{- else }}
+  {{- if .Stdout }}
   docker-json:
     stream: all
-    partial: true 
+    partial: true
     cri_flags: true
-  {{end}}
+  {{- end }}
+  {{- if eq .Format "json" }}
+  json.keys_under_root: true
+  {{- end }}
+  {{- end }}
+  {{- with processors . }}
+  processors:
+{{ toYaml . | indent 4 }}
+  {{- end }}
   fields:
       cluster: ${CLUSTER_ID}
       {{- range $key, $value := .Tags }}
-      {{ $key }}: "{{ $value }}"
+      {{ quote $key }}: {{ quote $value }}
       {{- end }}
+      {{- range $key, $value := .InOpts }}
+      {{ quote $key }}: {{ quote $value }}
+      {{- end }}
+      {{- range $key, $value := .OutOpts }}
+      {{ quote $key }}: {{ quote $value }}
+      {{- end }}
+  {{- if eq $.inputType "filestream" }}
+  # Harvester closing options
+  close.on_state_change.inactive: 5m
+  close.on_state_change.removed: false
+  close.on_state_change.renamed: false
+  {{- else }}
   tail_files: false
   # Harvester closing options
   close_eof: false
   close_inactive: 5m
   close_removed: false
   close_renamed: false
+  {{- end }}
   ignore_older: 48h  
   # State options
   clean_removed: true
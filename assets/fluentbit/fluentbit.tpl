@@ -0,0 +1,36 @@
+{{- range .configList }}
+[INPUT]
+    Name              tail
+    Tag               {{ $.tagPrefix }}.{{ .Name }}
+    Path              {{ .LogFile }}
+    DB                {{ $.db }}
+    {{- if .Stdout }}
+    Parser            docker
+    {{- else if eq .Format "json" }}
+    Parser            json
+    {{- else if eq .Format "nginx" }}
+    Parser            nginx
+    {{- else if eq .Format "apache" }}
+    Parser            apache2
+    {{- else if eq .Format "syslog" }}
+    Parser            syslog-rfc3164
+    {{- else if eq .Format "syslog_rfc5424" }}
+    Parser            syslog-rfc5424
+    {{- else if eq .Format "cri" }}
+    Parser            cri
+    {{- end }}
+    Refresh_Interval  10
+    Mem_Buf_Limit     5MB
+    Skip_Long_Lines   On
+
+[FILTER]
+    Name              record_modifier
+    Match             {{ $.tagPrefix }}.{{ .Name }}
+    Record            cluster ${CLUSTER_ID}
+    {{- range $key, $value := .Tags }}
+    Record            {{ quote $key }} {{ quote $value }}
+    {{- end }}
+    {{- range $key, $value := .OutOpts }}
+    Record            {{ quote $key }} {{ quote $value }}
+    {{- end }}
+{{ end }}
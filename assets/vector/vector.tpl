@@ -0,0 +1,46 @@
+sources:
+{{- range .sources }}
+  {{ .SourceID }}:
+    type: file
+    include:
+      - {{ printf "%q" .LogFile }}
+    data_dir: {{ printf "%q" $.dataDir }}
+    # Checkpoints are looked up by device and inode for garbage collection.
+    fingerprint:
+      strategy: device_and_inode
+{{- end }}
+
+transforms:
+{{- range .sources }}
+  {{ .TransformID }}:
+    type: remap
+    inputs:
+      - {{ .SourceID }}
+    source: |
+      {{- if .Stdout }}
+      # Docker json envelope, json payload is decoded only if it's unwrapped.
+      envelope, err = parse_json(.message)
+      if err == null {
+        .message = envelope.log
+        .stream = envelope.stream
+        {{- if eq .Format "json" }}
+        payload, err = parse_json(.message)
+        if err == null && is_object(payload) {
+          . = merge(., object!(payload))
+        }
+        {{- end }}
+      }
+      {{- else if eq .Format "json" }}
+      payload, err = parse_json(.message)
+      if err == null && is_object(payload) {
+        . = merge(., object!(payload))
+      }
+      {{- end }}
+      .cluster = get_env_var("CLUSTER_ID") ?? ""
+      {{- range $key, $value := .Tags }}
+      .{{ printf "%q" $key }} = {{ printf "%q" $value }}
+      {{- end }}
+      {{- range $key, $value := .OutOpts }}
+      .{{ printf "%q" $key }} = {{ printf "%q" $value }}
+      {{- end }}
+{{- end }}
@@ -50,6 +50,13 @@ output.elasticsearch:
     - {{ . }}
     {{- end }}
     index: logstash-%{+yyyy.MM.dd}
+    # Routes declared by log sources or namespaces, see LogConfig.OutOpts.
+    indices:
+    - index: "logstash-%{[route.index]}-%{+yyyy.MM.dd}"
+      when.has_fields: ["route.index"]
+    pipelines:
+    - pipeline: "%{[route.pipeline]}"
+      when.has_fields: ["route.pipeline"]
 {{- end }}
 
 {{- if eq .type "kafka" }}
@@ -60,5 +67,9 @@ output.kafka:
     - {{ . }}
     {{- end }}
     topic: {{ .topic }}
+    # Routes declared by log sources or namespaces, see LogConfig.OutOpts.
+    topics:
+    - topic: "%{[route.topic]}"
+      when.has_fields: ["route.topic"]
     version: {{ .version }}
 {{- end -}}
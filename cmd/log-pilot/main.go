@@ -2,21 +2,35 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/caicloud/log-pilot/pilot/config"
 	"github.com/caicloud/log-pilot/pilot/configurer"
-	"github.com/caicloud/log-pilot/pilot/configurer/filebeat"
+	"github.com/caicloud/log-pilot/pilot/configurer/fanout"
+	_ "github.com/caicloud/log-pilot/pilot/configurer/filebeat"
+	_ "github.com/caicloud/log-pilot/pilot/configurer/fluentbit"
+	_ "github.com/caicloud/log-pilot/pilot/configurer/native"
+	_ "github.com/caicloud/log-pilot/pilot/configurer/otel"
+	_ "github.com/caicloud/log-pilot/pilot/configurer/promtail"
+	_ "github.com/caicloud/log-pilot/pilot/configurer/vector"
 	"github.com/caicloud/log-pilot/pilot/discovery"
+	"github.com/caicloud/log-pilot/pilot/kube"
 	"github.com/caicloud/log-pilot/pilot/log"
-	"strings"
+	"github.com/caicloud/log-pilot/pilot/redaction"
+	"github.com/caicloud/log-pilot/pilot/routing"
+	"github.com/caicloud/log-pilot/pilot/throttle"
 )
 
 var (
-	template      = flag.String("path.template", "", "Template file path for filebeat")
-	filebeatHome  = flag.String("path.filebeat-home", "", "Filebeat home path")
+	configPath    = flag.String("config", "", "Config file path, flags set on command line override it")
+	cfgrName      = flag.String("configurer", "filebeat", "Configurers to use, see configurer.Names. Multiple configurers should be separated by \",\"")
+	template      = flag.String("path.template", "", "Template file path for the configurer, filebeat reloads it on change")
 	base          = flag.String("path.base", "/", "Directory which mount host path")
 	logPath       = flag.String("path.logs", "", "Logs path")
 	logPrefix     = flag.String("logPrefix", "caicloud", "Log prefix of the env parameters. Multiple prefixes should be separated by \",\"")
@@ -26,28 +40,151 @@ var (
 	logMaxBytes   = flag.Uint("log.maxSize", 10*1024*1024, "Max size of log file in bytes")
 	logMaxBackups = flag.Uint("log.maxBackups", 7, "Max backups of log files")
 	logToStderr   = flag.Bool("e", false, "Log to stderr")
+	levelDetect   = flag.Bool("level.detect", false, "Detect and normalize level of log lines for all sources")
+	levelMin      = flag.String("level.min", "", "Drop log lines whose level is lower than it: trace, debug, info, warn, error, fatal")
+	redactionPath = flag.String("redaction.rules", "", "Redaction rules file path")
+	routingPath   = flag.String("routing.rules", "", "Routing rules file path")
+	throttlePath  = flag.String("throttle.rules", "", "Throttle policies file path")
+	qThreshold    = flag.Int64("quarantine.threshold", 0, "Log growth rate in bytes per minute to quarantine a container, 0 means disabled")
+	qWindow       = flag.Duration("quarantine.window", 5*time.Minute, "How long the growth rate stays above or below threshold before quarantine or restore")
+	qMode         = flag.String("quarantine.mode", configurer.QuarantineSample, "How to degrade collecting: sample, drop_debug, pause")
+	qSampleRatio  = flag.Float64("quarantine.sampleRatio", 0.1, "Ratio of lines to keep in sample mode")
 )
 
 func main() {
+	// config sections of configurers, keyed by name
+	sections := make(map[string]interface{})
+	for _, name := range configurer.Names() {
+		f, _ := configurer.Lookup(name)
+		sections[name] = f.Config()
+		if f.Flags != nil {
+			f.Flags(flag.CommandLine, sections[name])
+		}
+	}
 	flag.Parse()
 
+	// flags set on command line, they are not overridden by the file
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	var file *config.Config
+	if *configPath != "" {
+		var err error
+		file, err = loadConfig(*configPath, sections, explicit)
+		if err != nil {
+			// logging is not configured yet
+			fmt.Fprintf(os.Stderr, "Error load config file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	log.Config(*logLevel, *logPath, *logToStderr, *logMaxBytes, *logMaxBackups)
 
 	baseDir, err := filepath.Abs(*base)
 	if err != nil {
 		log.Fatal("Invalid path.base:", err)
 	}
-	var cfgr configurer.Configurer
-	cfgr, err = filebeat.New(baseDir, *template, *filebeatHome)
-	if err != nil {
-		log.Fatalf("Error create configurer: %v", err)
+	var (
+		quarantine *configurer.QuarantineOptions
+		recorder   configurer.EventRecorder
+	)
+	if *qThreshold > 0 {
+		quarantine = &configurer.QuarantineOptions{
+			Threshold:   *qThreshold,
+			Window:      *qWindow,
+			Mode:        *qMode,
+			SampleRatio: *qSampleRatio,
+		}
+		if err := quarantine.Validate(); err != nil {
+			log.Fatalf("Invalid quarantine options: %v", err)
+		}
+	}
+	// Events are also recorded for inputs removed before they are read, so
+	// the recorder is created whenever kubernetes is reachable.
+	if r, err := kube.NewEventRecorder("log-pilot"); err != nil {
+		if quarantine != nil {
+			log.Fatalf("Error create event recorder: %v", err)
+		}
+		log.Warnf("Error create event recorder, events are not recorded: %v", err)
+	} else {
+		recorder = r
+	}
+
+	opts := &configurer.Options{
+		BaseDir:    baseDir,
+		Template:   *template,
+		Quarantine: quarantine,
+		Recorder:   recorder,
+	}
+	var backends []configurer.Configurer
+	for _, name := range parseList(*cfgrName) {
+		cfgr, err := configurer.New(name, opts, sections[name])
+		if err != nil {
+			log.Fatalf("Error create configurer %s: %v", name, err)
+		}
+		backends = append(backends, cfgr)
+	}
+	if len(backends) == 0 {
+		log.Fatal("No configurer is specified")
+	}
+	cfgr := backends[0]
+	if len(backends) > 1 {
+		cfgr, err = fanout.New(backends...)
+		if err != nil {
+			log.Fatalf("Error create configurer: %v", err)
+		}
 	}
 
-	d, err := discovery.New(baseDir, *logPrefix, cfgr, parseList(*bListNS), parseList(*wListNS))
+	var levelOpts *configurer.LevelOptions
+	if *levelMin != "" && !configurer.ValidLogLevel(*levelMin) {
+		log.Fatalf("Invalid level.min: %s", *levelMin)
+	}
+	if *levelDetect || *levelMin != "" {
+		levelOpts = &configurer.LevelOptions{Min: *levelMin}
+	}
+
+	var rules *redaction.Rules
+	if *redactionPath != "" {
+		rules, err = redaction.Load(*redactionPath)
+		if err != nil {
+			log.Fatalf("Error load redaction rules: %v", err)
+		}
+	}
+
+	var routes *routing.Rules
+	if *routingPath != "" {
+		routes, err = routing.Load(*routingPath)
+		if err != nil {
+			log.Fatalf("Error load routing rules: %v", err)
+		}
+	}
+
+	var policies *throttle.Rules
+	if *throttlePath != "" {
+		policies, err = throttle.Load(*throttlePath)
+		if err != nil {
+			log.Fatalf("Error load throttle policies: %v", err)
+		}
+	}
+
+	d, err := discovery.New(baseDir, *logPrefix, cfgr, settings(file), levelOpts, rules, routes, policies)
 	if err != nil {
 		log.Fatalf("Error create discovery: %v", err)
 	}
 
+	stopCh := make(chan struct{})
+	if file != nil {
+		r := &reloader{path: *configPath, file: file, explicit: explicit, discovery: d}
+		go func() {
+			if err := config.Watch(*configPath, stopCh, r.reload, func(err error) {
+				log.Errorf("Error watch config file: %v", err)
+			}); err != nil {
+				log.Errorf("Error watch config file, it will not be reloaded: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		if err := d.Start(); err != nil {
 			log.Fatalf("Error start discovery: %v", err)
@@ -59,6 +196,7 @@ func main() {
 	// Wait for Interrupt signal
 	<-c
 	log.Info("Received signal and shutdown")
+	close(stopCh)
 	d.Stop()
 	// Gracefully shutdown
 	time.Sleep(5 * time.Second)
@@ -75,3 +213,121 @@ func parseList(raw string) []string {
 	}
 	return splitted
 }
+
+// loadConfig loads the config file into flags and config sections of
+// configurers, flags set on command line take precedence.
+func loadConfig(path string, sections map[string]interface{}, explicit map[string]bool) (*config.Config, error) {
+	file, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	for name := range file.Configurer.Sections {
+		if _, ok := sections[name]; !ok {
+			return nil, fmt.Errorf("unknown configurer %q in config file", name)
+		}
+	}
+	for name, section := range sections {
+		if _, err := file.Configurer.Section(name, section); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyFlags(file, config.FlagNames(), explicit); err != nil {
+		return nil, err
+	}
+	// configurer flags are overridden by sections, parse them again
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// applyFlags sets flags which are not set on command line by the file,
+// flags unset in the file are reset to defaults.
+func applyFlags(file *config.Config, names []string, explicit map[string]bool) error {
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil || explicit[name] {
+			continue
+		}
+		value, ok := file.Flag(name)
+		if !ok {
+			value = f.DefValue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s in config file: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// settings returns settings of discovery from flags and the file.
+func settings(file *config.Config) discovery.Settings {
+	ret := discovery.Settings{
+		NamespaceBlacklist: parseList(*bListNS),
+		NamespaceWhitelist: parseList(*wListNS),
+	}
+	if file != nil {
+		ret.Tags = file.Enrichment.Tags
+	}
+	return ret
+}
+
+// reloader applies changes of safe fields in the config file, changes of
+// other fields are logged and take effect after restart.
+type reloader struct {
+	path      string
+	file      *config.Config
+	explicit  map[string]bool
+	discovery discovery.Discovery
+	lock      sync.Mutex
+}
+
+func (r *reloader) reload() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	file, err := config.Load(r.path)
+	if err != nil {
+		log.Errorf("Error reload config file, the old one is kept: %v", err)
+		return
+	}
+	changes := config.Diff(r.file, file)
+	if len(changes) == 0 {
+		return
+	}
+	for _, change := range changes {
+		if reloadable(change) {
+			log.Infof("Config changed: %s", change)
+		} else {
+			log.Warnf("Config changed: %s, it takes effect after restart", change)
+		}
+	}
+
+	// Flags are validated by setting them, the new file is kept only if all
+	// of them are valid, otherwise flags of the old file are restored.
+	err = applyFlags(file, config.Reloadable, r.explicit)
+	if err == nil {
+		err = log.SetLevel(*logLevel)
+	}
+	if err != nil {
+		log.Errorf("Error reload config file, the old one is kept: %v", err)
+		if err := applyFlags(r.file, config.Reloadable, r.explicit); err != nil {
+			log.Errorf("Error restore flags of the old config file: %v", err)
+		}
+		return
+	}
+	r.file = file
+	if err := r.discovery.Reload(settings(file)); err != nil {
+		log.Errorf("Error reload discovery: %v", err)
+	}
+}
+
+// reloadable reports whether the change of Diff can be applied at runtime.
+func reloadable(change string) bool {
+	for _, prefix := range []string{"log.level:", "selection.", "enrichment.tags."} {
+		if strings.HasPrefix(change, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of log-pilot config file, fields are the same as
+// flags, and flags set on command line take precedence. Unset fields mean
+// defaults of the flags, e.g.
+//
+//	log:
+//	  level: info
+//	discovery:
+//	  base: /host
+//	  logPrefix: [caicloud]
+//	selection:
+//	  namespaceBlacklist: [kube-system]
+//	enrichment:
+//	  tags:
+//	    cluster: prod
+//	gc:
+//	  maxWait: 6h
+//	configurer:
+//	  names: [filebeat]
+//	  template: /pilot/filebeat.tpl
+//	  filebeat:
+//	    home: /usr/share/filebeat
+//
+// Fields of selection, enrichment.tags and log.level are reloaded when the
+// file is changed, see Reloadable.
+type Config struct {
+	Log        Log        `yaml:"log"`
+	Discovery  Discovery  `yaml:"discovery"`
+	Selection  Selection  `yaml:"selection"`
+	Enrichment Enrichment `yaml:"enrichment"`
+	GC         GC         `yaml:"gc"`
+	Quarantine Quarantine `yaml:"quarantine"`
+	Configurer Configurer `yaml:"configurer"`
+}
+
+// Log configures logs of log-pilot.
+type Log struct {
+	Level      *string `yaml:"level"`
+	Path       *string `yaml:"path"`
+	MaxSize    *uint   `yaml:"maxSize"`
+	MaxBackups *uint   `yaml:"maxBackups"`
+	ToStderr   *bool   `yaml:"toStderr"`
+}
+
+// Discovery configures how containers and their logs are found.
+type Discovery struct {
+	// Base is the directory which mounts host path.
+	Base *string `yaml:"base"`
+	// LogPrefix are prefixes of env parameters declaring logs.
+	LogPrefix []string `yaml:"logPrefix"`
+}
+
+// Selection configures which containers are collected.
+type Selection struct {
+	NamespaceWhitelist []string `yaml:"namespaceWhitelist"`
+	NamespaceBlacklist []string `yaml:"namespaceBlacklist"`
+}
+
+// Enrichment configures what are added to log records.
+type Enrichment struct {
+	// Tags are added to all log records, e.g. cluster name.
+	Tags           map[string]string `yaml:"tags"`
+	LevelDetect    *bool             `yaml:"levelDetect"`
+	LevelMin       *string           `yaml:"levelMin"`
+	RedactionRules *string           `yaml:"redactionRules"`
+	RoutingRules   *string           `yaml:"routingRules"`
+	ThrottleRules  *string           `yaml:"throttleRules"`
+}
+
+// GC configures removal of inputs of destroyed containers.
+type GC struct {
+	// MaxWait is how long to wait for logs to be read, 0 means forever.
+	MaxWait *time.Duration `yaml:"maxWait"`
+}
+
+// Quarantine configures degrading of runaway loggers.
+type Quarantine struct {
+	Threshold   *int64         `yaml:"threshold"`
+	Window      *time.Duration `yaml:"window"`
+	Mode        *string        `yaml:"mode"`
+	SampleRatio *float64       `yaml:"sampleRatio"`
+}
+
+// Configurer selects configurers, other keys are config sections of them,
+// see configurer.Factory.Config.
+type Configurer struct {
+	Names    []string               `yaml:"names"`
+	Template *string                `yaml:"template"`
+	Sections map[string]interface{} `yaml:",inline"`
+}
+
+// Load reads config file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("error decode config file: %v", err)
+	}
+	return cfg, nil
+}
+
+// Section decodes config section of the configurer into out, fields absent
+// in the file are kept. It returns false if there is no such section.
+func (c *Configurer) Section(name string, out interface{}) (bool, error) {
+	raw, ok := c.Sections[name]
+	if !ok {
+		return false, nil
+	}
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return true, err
+	}
+	if err := yaml.UnmarshalStrict(data, out); err != nil {
+		return true, fmt.Errorf("error decode config of %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// flags maps fields to flags, values are formatted as flag.Value.Set
+// accepts, ok is false if the field is unset.
+var flags = map[string]func(c *Config) (value string, ok bool){
+	"logLevel":             func(c *Config) (string, bool) { return str(c.Log.Level) },
+	"path.logs":            func(c *Config) (string, bool) { return str(c.Log.Path) },
+	"log.maxSize":          func(c *Config) (string, bool) { return uintStr(c.Log.MaxSize) },
+	"log.maxBackups":       func(c *Config) (string, bool) { return uintStr(c.Log.MaxBackups) },
+	"e":                    func(c *Config) (string, bool) { return boolStr(c.Log.ToStderr) },
+	"path.base":            func(c *Config) (string, bool) { return str(c.Discovery.Base) },
+	"logPrefix":            func(c *Config) (string, bool) { return list(c.Discovery.LogPrefix) },
+	"namespace.whitelist":  func(c *Config) (string, bool) { return list(c.Selection.NamespaceWhitelist) },
+	"namespace.blacklist":  func(c *Config) (string, bool) { return list(c.Selection.NamespaceBlacklist) },
+	"level.detect":         func(c *Config) (string, bool) { return boolStr(c.Enrichment.LevelDetect) },
+	"level.min":            func(c *Config) (string, bool) { return str(c.Enrichment.LevelMin) },
+	"redaction.rules":      func(c *Config) (string, bool) { return str(c.Enrichment.RedactionRules) },
+	"routing.rules":        func(c *Config) (string, bool) { return str(c.Enrichment.RoutingRules) },
+	"throttle.rules":       func(c *Config) (string, bool) { return str(c.Enrichment.ThrottleRules) },
+	"filebeat.gc-max-wait": func(c *Config) (string, bool) { return durationStr(c.GC.MaxWait) },
+	"quarantine.threshold": func(c *Config) (string, bool) {
+		if c.Quarantine.Threshold == nil {
+			return "", false
+		}
+		return strconv.FormatInt(*c.Quarantine.Threshold, 10), true
+	},
+	"quarantine.window": func(c *Config) (string, bool) { return durationStr(c.Quarantine.Window) },
+	"quarantine.mode":   func(c *Config) (string, bool) { return str(c.Quarantine.Mode) },
+	"quarantine.sampleRatio": func(c *Config) (string, bool) {
+		if c.Quarantine.SampleRatio == nil {
+			return "", false
+		}
+		return strconv.FormatFloat(*c.Quarantine.SampleRatio, 'g', -1, 64), true
+	},
+	"configurer":    func(c *Config) (string, bool) { return list(c.Configurer.Names) },
+	"path.template": func(c *Config) (string, bool) { return str(c.Configurer.Template) },
+}
+
+// Reloadable are flags which are reloaded when the file is changed,
+// enrichment.tags are reloaded too.
+var Reloadable = []string{"logLevel", "namespace.whitelist", "namespace.blacklist"}
+
+// FlagNames returns names of flags which can be set by the file.
+func FlagNames() []string {
+	ret := make([]string, 0, len(flags))
+	for name := range flags {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Flag returns value of the flag set by the file, ok is false if it's
+// unset.
+func (c *Config) Flag(name string) (value string, ok bool) {
+	f, exist := flags[name]
+	if !exist {
+		return "", false
+	}
+	return f(c)
+}
+
+func str(p *string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	return *p, true
+}
+
+func boolStr(p *bool) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	return strconv.FormatBool(*p), true
+}
+
+func uintStr(p *uint) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(*p), 10), true
+}
+
+func durationStr(p *time.Duration) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	return p.String(), true
+}
+
+func list(l []string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	return strings.Join(l, ","), true
+}
+
+// Diff returns changes from old to new, e.g. `selection.namespaceBlacklist:
+// [] -> [kube-system]`, they are sorted by field path.
+func Diff(old, new *Config) []string {
+	var ret []string
+	diff("", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), &ret)
+	sort.Strings(ret)
+	return ret
+}
+
+func diff(path string, a, b reflect.Value, ret *[]string) {
+	switch a.Kind() {
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			name := strings.Split(a.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			p := path
+			if name != "" {
+				p = joinPath(path, name)
+			}
+			diff(p, a.Field(i), b.Field(i), ret)
+		}
+		return
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		for _, k := range append(a.MapKeys(), b.MapKeys()...) {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		for name, k := range keys {
+			diff(joinPath(path, name), a.MapIndex(k), b.MapIndex(k), ret)
+		}
+		return
+	}
+	av, bv := show(a), show(b)
+	if av != bv {
+		*ret = append(*ret, fmt.Sprintf("%s: %s -> %s", path, av, bv))
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// show formats v for Diff, unset values are shown as <unset>.
+func show(v reflect.Value) string {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return "<unset>"
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Kind() == reflect.Slice && v.IsNil() {
+		return "<unset>"
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "log-pilot.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, err := Load(writeConfig(t, dir, `
+log:
+  level: debug
+  toStderr: false
+selection:
+  namespaceBlacklist: [kube-system, default]
+gc:
+  maxWait: 0s
+quarantine:
+  sampleRatio: 0.5
+configurer:
+  names: [filebeat, native]
+  filebeat:
+    home: /usr/share/filebeat
+    shards: 4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		"logLevel":               "debug",
+		"e":                      "false",
+		"namespace.blacklist":    "kube-system,default",
+		"filebeat.gc-max-wait":   "0s",
+		"quarantine.sampleRatio": "0.5",
+		"configurer":             "filebeat,native",
+	} {
+		if v, ok := cfg.Flag(name); !ok || v != expect {
+			t.Errorf("%s: expect %q, got %q, %v", name, expect, v, ok)
+		}
+	}
+	for _, name := range []string{"path.base", "namespace.whitelist", "no.such.flag"} {
+		if v, ok := cfg.Flag(name); ok {
+			t.Errorf("%s: expect unset, got %q", name, v)
+		}
+	}
+
+	section := struct {
+		Home    string        `yaml:"home"`
+		Shards  int           `yaml:"shards"`
+		Version string        `yaml:"version"`
+		Delay   time.Duration `yaml:"delay"`
+	}{Version: "7.17.0", Delay: time.Second}
+	if ok, err := cfg.Configurer.Section("filebeat", &section); !ok || err != nil {
+		t.Fatalf("expect section decoded, got %v, %v", ok, err)
+	}
+	if section.Home != "/usr/share/filebeat" || section.Shards != 4 || section.Version != "7.17.0" || section.Delay != time.Second {
+		t.Errorf("unexpected section: %+v", section)
+	}
+	if ok, _ := cfg.Configurer.Section("native", &section); ok {
+		t.Error("expect no native section")
+	}
+
+	if _, err := Load(writeConfig(t, dir, "selection:\n  namespaces: [a]\n")); err == nil {
+		t.Error("expect error of unknown field")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	level := "debug"
+	old := &Config{
+		Selection:  Selection{NamespaceBlacklist: []string{"kube-system"}},
+		Enrichment: Enrichment{Tags: map[string]string{"cluster": "a", "zone": "z1"}},
+	}
+	new := &Config{
+		Log:        Log{Level: &level},
+		Enrichment: Enrichment{Tags: map[string]string{"cluster": "b", "zone": "z1"}},
+	}
+	expect := []string{
+		"enrichment.tags.cluster: a -> b",
+		"log.level: <unset> -> debug",
+		"selection.namespaceBlacklist: [kube-system] -> <unset>",
+	}
+	if changes := Diff(old, new); !reflect.DeepEqual(changes, expect) {
+		t.Errorf("expect %q, got %q", expect, changes)
+	}
+	if changes := Diff(new, new); len(changes) != 0 {
+		t.Errorf("expect no change, got %q", changes)
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"path/filepath"
+	"time"
+
+	"gopkg.in/fsnotify/fsnotify.v1"
+)
+
+// reloadDelay coalesces events of a change, editors and ConfigMap updates
+// emit several events.
+const reloadDelay = time.Second
+
+// Watch calls onChange when the file is changed until stopCh is closed.
+// The directory is watched since the file may be replaced, e.g. ConfigMap
+// volumes swap the "..data" symlink, see cmd/filebeat-keeper.
+func Watch(path string, stopCh <-chan struct{}, onChange func(), onError func(err error)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	var reloadCh <-chan time.Time
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case ev := <-w.Events:
+			if isChangeEvent(path, ev) {
+				reloadCh = time.After(reloadDelay)
+			}
+		case err := <-w.Errors:
+			onError(err)
+		case <-reloadCh:
+			reloadCh = nil
+			onChange()
+		}
+	}
+}
+
+func isChangeEvent(path string, ev fsnotify.Event) bool {
+	if filepath.Base(ev.Name) == "..data" {
+		return ev.Op&fsnotify.Create == fsnotify.Create
+	}
+	return filepath.Clean(ev.Name) == filepath.Clean(path) &&
+		ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0
+}
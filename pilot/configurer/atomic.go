@@ -0,0 +1,86 @@
+package configurer
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tmpSuffix is in names of temporary files, they start with "." and don't
+// end with the extension, so that agents don't load them by globs like
+// inputs.d/*.yml.
+const tmpSuffix = ".tmp"
+
+// WriteFileAtomic writes data to a temporary file in the same directory,
+// syncs and renames it to path, so readers never see a partial file. It
+// does nothing if the file has the same content, changed reports whether
+// the file is written.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (changed bool, err error) {
+	if old, err := ioutil.ReadFile(path); err == nil && sha256.Sum256(old) == sha256.Sum256(data) {
+		return false, nil
+	}
+
+	dir, base := filepath.Split(path)
+	f, err := ioutil.TempFile(dir, "."+base+tmpSuffix)
+	if err != nil {
+		return false, err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err = f.Close(); err != nil {
+		return false, err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return false, err
+	}
+
+	// sync the directory so the rename survives a crash
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return true, nil
+}
+
+// isTempFile reports whether base is a temporary file of WriteFileAtomic.
+func isTempFile(base string) bool {
+	return strings.HasPrefix(base, ".") && strings.Contains(base, tmpSuffix)
+}
+
+// RemoveTempFiles removes temporary files left by interrupted writes.
+func RemoveTempFiles(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, fi := range files {
+		if fi.IsDir() || !isTempFile(fi.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, fi.Name())
+	}
+	return removed, nil
+}
@@ -0,0 +1,51 @@
+package configurer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configurer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.yml")
+
+	for i, c := range []struct {
+		content string
+		changed bool
+	}{
+		{"a", true},
+		{"a", false},
+		{"b", true},
+	} {
+		changed, err := WriteFileAtomic(path, []byte(c.content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != c.changed {
+			t.Errorf("%d: expect changed %v, got %v", i, c.changed, changed)
+		}
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "b" {
+		t.Errorf("expect b, got %q", data)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ".a.yml.tmp123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := RemoveTempFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != ".a.yml.tmp123" {
+		t.Errorf("expect temporary file removed, got %v", removed)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expect a.yml kept: %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package configurer
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/caicloud/log-pilot/pilot/container"
 )
 
@@ -14,6 +17,51 @@ type Configurer interface {
 	OnDestroy(ev *ContainerDestroyEvent) error
 }
 
+// EventRecorder records events of pods, e.g. kube.EventRecorder.
+type EventRecorder interface {
+	Event(namespace, pod, podID, eventType, reason, message string)
+}
+
+// Quarantine modes, see QuarantineOptions.Mode.
+const (
+	// QuarantineSample keeps a ratio of lines.
+	QuarantineSample = "sample"
+	// QuarantineDropDebug drops lines lower than info.
+	QuarantineDropDebug = "drop_debug"
+	// QuarantinePause drops all lines.
+	QuarantinePause = "pause"
+)
+
+// QuarantineOptions defines how runaway loggers are detected and degraded.
+type QuarantineOptions struct {
+	// Threshold is the growth rate in bytes per minute, 0 means disabled.
+	Threshold int64
+	// Window is how long the rate should stay above threshold before the
+	// container is degraded, and stay below threshold before it's restored.
+	Window time.Duration
+	// Mode is one of QuarantineSample, QuarantineDropDebug and QuarantinePause.
+	Mode string
+	// SampleRatio is used by QuarantineSample.
+	SampleRatio float64
+}
+
+// Validate checks mode and sample ratio.
+func (o *QuarantineOptions) Validate() error {
+	switch o.Mode {
+	case QuarantineSample:
+		if o.SampleRatio < 0 || o.SampleRatio > 1 {
+			return fmt.Errorf("sample ratio should be in [0, 1]")
+		}
+	case QuarantineDropDebug, QuarantinePause:
+	default:
+		return fmt.Errorf("unknown quarantine mode %q", o.Mode)
+	}
+	if o.Window < 0 {
+		return fmt.Errorf("window can not be negative")
+	}
+	return nil
+}
+
 // ContainerAddEvent contains data for handling container update
 type ContainerAddEvent struct {
 	Container  container.Container
@@ -35,19 +83,161 @@ type LogConfig struct {
 	// For example, pod informations, user defined tags.
 	Tags   map[string]string
 	InOpts map[string]string
-	Stdout bool
+	// OutOpts are added to log record as fields, they are used by output
+	// to route the record, see OutOptIndex, OutOptTopic and OutOptPipeline.
+	OutOpts map[string]string
+	Stdout  bool
+	// Pattern is the user supplied expression for LogFormatRegex and
+	// LogFormatDissect.
+	Pattern string
+	// JSONDecode decodes json payload in the log line, it's mostly used
+	// by stdout whose payload is wrapped by docker json envelope.
+	// nil means disabled.
+	JSONDecode *JSONDecodeOptions
+	// Timestamp extracts the time when the event happened, nil means the
+	// time when the line is read is used.
+	Timestamp *TimestampOptions
+	// Level detects severity of the log line, nil means disabled.
+	Level *LevelOptions
+	// Redactions mask sensitive content before the line leaves the node.
+	// They are applied in order to message and fields decoded from it.
+	// Configurers which can't apply them reject the container, see
+	// RejectRedactions.
+	Redactions []RedactionRule
+	// Throttle limits rate of the source, nil means unlimited.
+	Throttle *ThrottleOptions
+}
+
+// ThrottleOptions defines rate limits and sampling of a log source. Zero
+// limits mean unlimited.
+type ThrottleOptions struct {
+	LinesPerSecond int
+	LinesBurst     int
+	BytesPerSecond int
+	BytesBurst     int
+	// Sampling keeps lines of a level by ratio, "*" matches all levels.
+	Sampling map[string]float64
+}
+
+// RedactionRule replaces content matches Pattern with Replacement.
+type RedactionRule struct {
+	Name        string
+	Pattern     string
+	IgnoreCase  bool
+	Replacement string
+}
+
+// RejectRedactions returns an error if any log of the container has
+// redaction rules. It's used by configurers which can't apply them, so
+// sensitive content is never shipped unmasked.
+func RejectRedactions(name string, ev *ContainerAddEvent) error {
+	for _, cfg := range ev.LogConfigs {
+		if len(cfg.Redactions) > 0 {
+			return fmt.Errorf("%s doesn't support redaction, but log %s of container %s has %d rules",
+				name, cfg.Name, ev.Container.ID, len(cfg.Redactions))
+		}
+	}
+	return nil
+}
+
+// LevelOptions defines how severity of the log line is detected.
+type LevelOptions struct {
+	// Min drops lines whose level is lower than it, empty means keeping all.
+	Min string
+}
+
+// LogLevels are normalized levels from low to high.
+var LogLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// ValidLogLevel reports whether l is one of LogLevels.
+func ValidLogLevel(l string) bool {
+	for _, lv := range LogLevels {
+		if l == lv {
+			return true
+		}
+	}
+	return false
+}
+
+// TimestampOptions defines how to extract timestamp from the log line.
+// One of Field and Pattern should be set.
+type TimestampOptions struct {
+	// Field is the parsed field contains timestamp, e.g. nginx.time_local.
+	Field string
+	// Pattern is a regular expression whose first capture group is the
+	// timestamp.
+	Pattern string
+	// Layouts are go style time layouts, e.g. 2006-01-02T15:04:05Z07:00.
+	// They are tried in order.
+	Layouts []string
+	// Timezone is used when the timestamp doesn't contain one,
+	// e.g. Asia/Shanghai, +08:00.
+	Timezone string
 }
 
+// JSONDecodeOptions defines how to decode json payload in the log line.
+// Lines which are not json are kept as plain text.
+type JSONDecodeOptions struct {
+	// Target is the key decoded fields put under, empty means top level.
+	Target string
+	// OverwriteKeys overwrites existing keys with decoded fields.
+	OverwriteKeys bool
+	// AddErrorKey adds an error key to the record when decode failed.
+	AddErrorKey bool
+}
+
+// Keys of LogConfig.OutOpts.
+const (
+	// OutOptIndex is the suffix of elasticsearch index.
+	OutOptIndex = "route.index"
+	// OutOptTopic is the kafka topic.
+	OutOptTopic = "route.topic"
+	// OutOptPipeline is the elasticsearch ingest pipeline.
+	OutOptPipeline = "route.pipeline"
+)
+
 type LogFormat string
 
 const (
 	LogFormatJSON  = "json"
 	LogFormatPlain = "plain"
+	// LogFormatLogfmt parses key=value pairs, e.g. `level=info msg="hello"`.
+	LogFormatLogfmt = "logfmt"
+	// LogFormatCRI parses lines written by CRI runtimes,
+	// e.g. `2019-01-01T00:00:00.000Z stdout F message`.
+	LogFormatCRI = "cri"
+	// LogFormatSyslog parses RFC3164 syslog lines.
+	LogFormatSyslog = "syslog"
+	// LogFormatSyslogRFC5424 parses RFC5424 syslog lines.
+	LogFormatSyslogRFC5424 = "syslog_rfc5424"
+	// LogFormatNginx parses nginx access logs in combined format.
+	LogFormatNginx = "nginx"
+	// LogFormatApache parses apache access logs in combined format.
+	LogFormatApache = "apache"
+	// LogFormatRegex parses lines with a regular expression, every named
+	// group becomes a field.
+	LogFormatRegex = "regex"
+	// LogFormatDissect parses lines with a dissect tokenizer.
+	LogFormatDissect = "dissect"
 )
 
+// ParseLogFormat converts a user defined format name to LogFormat, unknown
+// names fall back to LogFormatPlain.
+func ParseLogFormat(s string) (LogFormat, bool) {
+	switch f := LogFormat(s); f {
+	case LogFormatJSON, LogFormatPlain, LogFormatLogfmt, LogFormatCRI,
+		LogFormatSyslog, LogFormatSyslogRFC5424, LogFormatNginx,
+		LogFormatApache, LogFormatRegex, LogFormatDissect:
+		return f, true
+	}
+	return LogFormatPlain, false
+}
+
 type InputConfigFile struct {
-	Namespace   string
-	Pod         string
+	Namespace string
+	Pod       string
+	// PodID is empty if the file doesn't record it.
+	PodID       string
 	Container   string
 	ContainerID string
 	Version     string
@@ -0,0 +1,222 @@
+package fanout
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+// Errors contains errors of backends, keyed by backend name.
+type Errors map[string]error
+
+func (e Errors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", name, e[name]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// PartialError is returned if some of backends fail, the others have handled
+// the event, so the caller should keep the container rather than forget it.
+type PartialError struct {
+	Errors
+}
+
+// IsPartial returns true if err is returned because some of backends fail.
+func IsPartial(err error) bool {
+	_, ok := err.(*PartialError)
+	return ok
+}
+
+const (
+	defaultTimeout = 30 * time.Second
+	queueSize      = 1024
+)
+
+// fanoutConfigurer forwards events to all backends. Each backend has its own
+// queue, so events are handled in order by each backend, and a slow backend
+// doesn't block the others: the caller waits at most timeout for backends,
+// events of the slow one are kept in its queue and reported as failures.
+type fanoutConfigurer struct {
+	name     string
+	backends []*backend
+	timeout  time.Duration
+	done     chan struct{}
+	logger   log.Logger
+}
+
+type backend struct {
+	configurer.Configurer
+	queue chan func()
+}
+
+func (b *backend) run(done <-chan struct{}) {
+	for {
+		select {
+		case fn := <-b.queue:
+			fn()
+		case <-done:
+			return
+		}
+	}
+}
+
+// New creates a configurer which forwards events to backends. Names of
+// backends must be unique.
+func New(backends ...configurer.Configurer) (configurer.Configurer, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("at least one backend is required")
+	}
+	c := &fanoutConfigurer{
+		timeout: defaultTimeout,
+		done:    make(chan struct{}),
+		logger:  log.NewLogger("configurer"),
+	}
+	names := make([]string, 0, len(backends))
+	seen := make(map[string]struct{})
+	for _, b := range backends {
+		if _, dup := seen[b.Name()]; dup {
+			return nil, fmt.Errorf("duplicated backend %s", b.Name())
+		}
+		seen[b.Name()] = struct{}{}
+		names = append(names, b.Name())
+		c.backends = append(c.backends, &backend{
+			Configurer: b,
+			queue:      make(chan func(), queueSize),
+		})
+	}
+	c.name = "fanout(" + strings.Join(names, ",") + ")"
+	for _, b := range c.backends {
+		go b.run(c.done)
+	}
+	return c, nil
+}
+
+func (c *fanoutConfigurer) Name() string {
+	return c.name
+}
+
+type result struct {
+	name string
+	err  error
+}
+
+// each queues fn for all backends and waits for them at most timeout. It
+// returns Errors if all backends fail, or PartialError if some of them fail.
+func (c *fanoutConfigurer) each(op string, fn func(b configurer.Configurer) error) error {
+	errs := Errors{}
+	pending := make(map[string]struct{})
+	results := make(chan result, len(c.backends))
+	for _, b := range c.backends {
+		b := b
+		job := func() {
+			results <- result{name: b.Name(), err: fn(b.Configurer)}
+		}
+		select {
+		case b.queue <- job:
+			pending[b.Name()] = struct{}{}
+		default:
+			errs[b.Name()] = fmt.Errorf("queue is full")
+		}
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.name)
+			if r.err != nil {
+				errs[r.name] = r.err
+			}
+		case <-timer.C:
+			for name := range pending {
+				errs[name] = fmt.Errorf("not done in %v, it's kept in queue", c.timeout)
+			}
+			pending = nil
+		}
+	}
+
+	for name, err := range errs {
+		c.logger.Errorf("%s of %s failed: %v", op, name, err)
+	}
+	switch {
+	case len(errs) == 0:
+		return nil
+	case len(errs) == len(c.backends):
+		return errs
+	default:
+		return &PartialError{Errors: errs}
+	}
+}
+
+func (c *fanoutConfigurer) Start() error {
+	return c.each("start", func(b configurer.Configurer) error {
+		return b.Start()
+	})
+}
+
+func (c *fanoutConfigurer) Stop() {
+	c.each("stop", func(b configurer.Configurer) error {
+		b.Stop()
+		return nil
+	})
+	close(c.done)
+}
+
+// BootstrapCheck merges config files of backends, keys are prefixed with
+// backend names since a container has a file for each backend. Files of
+// working backends are returned along with PartialError.
+func (c *fanoutConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	var (
+		lock sync.Mutex
+		done bool
+	)
+	ret := make(map[string]*configurer.InputConfigFile)
+	err := c.each("bootstrap check", func(b configurer.Configurer) error {
+		files, err := b.BootstrapCheck()
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		// Files of a backend which is timed out are dropped
+		if done {
+			return nil
+		}
+		for id, f := range files {
+			ret[b.Name()+"/"+id] = f
+		}
+		return nil
+	})
+	lock.Lock()
+	done = true
+	lock.Unlock()
+	if err != nil && !IsPartial(err) {
+		return nil, err
+	}
+	return ret, err
+}
+
+func (c *fanoutConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	return c.each("add "+ev.Container.ID, func(b configurer.Configurer) error {
+		return b.OnAdd(ev)
+	})
+}
+
+func (c *fanoutConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	return c.each("destroy "+ev.Container.ID, func(b configurer.Configurer) error {
+		return b.OnDestroy(ev)
+	})
+}
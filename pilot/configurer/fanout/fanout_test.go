@@ -0,0 +1,120 @@
+package fanout
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+type fakeConfigurer struct {
+	name  string
+	err   error
+	block chan struct{}
+	added []string
+}
+
+func (c *fakeConfigurer) Name() string { return c.name }
+func (c *fakeConfigurer) Start() error { return c.err }
+func (c *fakeConfigurer) Stop()        {}
+
+func (c *fakeConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return map[string]*configurer.InputConfigFile{
+		"1": {ContainerID: "1", Path: c.name + "/1"},
+	}, nil
+}
+
+func (c *fakeConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	if c.block != nil {
+		<-c.block
+	}
+	if c.err != nil {
+		return c.err
+	}
+	c.added = append(c.added, ev.Container.ID)
+	return nil
+}
+
+func (c *fakeConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	return c.err
+}
+
+func TestFanout(t *testing.T) {
+	a := &fakeConfigurer{name: "a"}
+	b := &fakeConfigurer{name: "b", err: fmt.Errorf("broken")}
+	c, err := New(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := &configurer.ContainerAddEvent{Container: container.Container{ID: "1"}}
+	err = c.OnAdd(ev)
+	if !IsPartial(err) || err.Error() != "b: broken" {
+		t.Errorf("expect partial error of b, got %v", err)
+	}
+	if len(a.added) != 1 {
+		t.Errorf("expect event forwarded to a, got %v", a.added)
+	}
+
+	files, err := c.BootstrapCheck()
+	if !IsPartial(err) {
+		t.Fatalf("expect partial error, got %v", err)
+	}
+	if len(files) != 1 || files["a/1"] == nil {
+		t.Errorf("unexpected files: %v", files)
+	}
+
+	a.err = fmt.Errorf("down")
+	err = c.OnAdd(ev)
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expect errors of both backends, got %v", err)
+	}
+	if errs.Error() != "a: down; b: broken" {
+		t.Errorf("unexpected message: %s", errs.Error())
+	}
+	c.Stop()
+
+	if _, err := New(a, &fakeConfigurer{name: "a"}); err == nil {
+		t.Error("expect error for duplicated backends")
+	}
+}
+
+func TestFanoutSlowBackend(t *testing.T) {
+	a := &fakeConfigurer{name: "a"}
+	b := &fakeConfigurer{name: "b", block: make(chan struct{})}
+	c, err := New(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	c.(*fanoutConfigurer).timeout = 50 * time.Millisecond
+
+	for _, id := range []string{"1", "2"} {
+		ev := &configurer.ContainerAddEvent{Container: container.Container{ID: id}}
+		err := c.OnAdd(ev)
+		if !IsPartial(err) {
+			t.Fatalf("expect partial error, got %v", err)
+		}
+		if _, ok := err.(*PartialError).Errors["b"]; !ok {
+			t.Errorf("expect b timed out, got %v", err)
+		}
+	}
+	if len(a.added) != 2 {
+		t.Errorf("expect events handled by a, got %v", a.added)
+	}
+
+	// Queued events are handled in order once b is back
+	close(b.block)
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.added) != 2 || b.added[0] != "1" || b.added[1] != "2" {
+		t.Errorf("expect queued events handled by b, got %v", b.added)
+	}
+}
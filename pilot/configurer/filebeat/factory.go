@@ -0,0 +1,100 @@
+package filebeat
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+// Config is the config section of filebeat configurer.
+type Config struct {
+	// Template defaults to configurer.Options.Template.
+	Template string `yaml:"template"`
+	Home     string `yaml:"home"`
+	// Version is the version of filebeat, e.g. 7.17.0. It's used to check
+	// InputType and processors rendered for log configs, empty skips the
+	// checks. The registry format is detected from its layout.
+	Version string `yaml:"version"`
+	// InputType is log or filestream, filestream requires filebeat 7.14+.
+	InputType string `yaml:"inputType"`
+	// GCMaxWait is how long to wait for logs of a destroyed container to be
+	// read before its input is removed anyway, 0 means forever.
+	GCMaxWait time.Duration `yaml:"gcMaxWait"`
+	// WriteDelay is how long to coalesce changes of inputs before writing
+	// them, so mass rescheduling causes a few reloads of filebeat, 0 means
+	// writing immediately.
+	WriteDelay time.Duration `yaml:"writeDelay"`
+	// Shards aggregates inputs into a bounded number of files, 0 means a
+	// file per container.
+	Shards int `yaml:"shards"`
+}
+
+const (
+	inputTypeLog        = "log"
+	inputTypeFilestream = "filestream"
+)
+
+func (c *Config) validate() error {
+	if c.Shards < 0 {
+		return fmt.Errorf("invalid shards %d", c.Shards)
+	}
+	switch c.InputType {
+	case "":
+		c.InputType = inputTypeLog
+	case inputTypeLog, inputTypeFilestream:
+	default:
+		return fmt.Errorf("unknown input type %q", c.InputType)
+	}
+	if c.Version == "" {
+		return nil
+	}
+	major, minor, err := parseVersion(c.Version)
+	if err != nil {
+		return err
+	}
+	if c.InputType == inputTypeFilestream && (major < 7 || major == 7 && minor < 14) {
+		return fmt.Errorf("filestream inputs require filebeat 7.14+, got %s", c.Version)
+	}
+	return nil
+}
+
+// parseVersion parses major and minor of version like 7.17.0.
+func parseVersion(v string) (major, minor int, err error) {
+	items := strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3)
+	if major, err = strconv.Atoi(items[0]); err != nil {
+		return 0, 0, fmt.Errorf("invalid version %q", v)
+	}
+	if len(items) > 1 {
+		if minor, err = strconv.Atoi(items[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid version %q", v)
+		}
+	}
+	return major, minor, nil
+}
+
+func init() {
+	configurer.Register("filebeat", configurer.Factory{
+		Config: func() interface{} { return &Config{} },
+		Flags: func(fs *flag.FlagSet, cfg interface{}) {
+			c := cfg.(*Config)
+			fs.StringVar(&c.Home, "path.filebeat-home", "", "Filebeat home path")
+			// defaults to the image built by build/filebeat
+			fs.StringVar(&c.Version, "filebeat.version", "6.4.2", "Filebeat version, e.g. 7.17.0. Log formats, timestamp, level and throttle which rely on filebeat 7.x processors are ignored on older versions, and sources with redaction are not collected")
+			fs.StringVar(&c.InputType, "filebeat.input-type", inputTypeLog, "Filebeat input type: log, filestream (7.14+)")
+			fs.DurationVar(&c.WriteDelay, "filebeat.write-delay", time.Second, "How long to coalesce changes of inputs before writing them, 0 means writing immediately")
+			fs.IntVar(&c.Shards, "filebeat.shards", 0, "Number of aggregated input files, 0 means a file per container")
+			fs.DurationVar(&c.GCMaxWait, "filebeat.gc-max-wait", 6*time.Hour, "Max time to wait for logs of a destroyed container to be read before its input is removed, 0 means forever")
+		},
+		New: func(opts *configurer.Options, cfg interface{}) (configurer.Configurer, error) {
+			c := *cfg.(*Config)
+			if c.Template == "" {
+				c.Template = opts.Template
+			}
+			return New(opts.BaseDir, c, opts.Quarantine, opts.Recorder)
+		},
+	})
+}
@@ -2,7 +2,7 @@ package filebeat
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -18,56 +18,109 @@ import (
 	"github.com/caicloud/log-pilot/pilot/configurer"
 	"github.com/caicloud/log-pilot/pilot/log"
 
-	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/go-ucfg"
 )
 
 // logStates contains states in filebeat registry and related to the container
 type logStates struct {
 	*container.Container
+	// ev is the last add event of the container, it's nil if the container
+	// is unknown, e.g. destroyed before log-pilot starts.
+	ev *configurer.ContainerAddEvent
+	// states are the last observed states.
 	states []RegistryState
-	ts     time.Time
+	// destroyed is when the container is destroyed.
+	destroyed time.Time
 }
 
 type filebeatConfigurer struct {
 	name string
 	base string
 	// Filebeat home path.
-	filebeatHome   string
-	tmpl           *template.Template
-	closeCh        chan bool
-	watchDuration  time.Duration
+	filebeatHome string
+	// inputType is log or filestream.
+	inputType string
+	// version is the version of filebeat, empty means unknown.
+	version string
+	// templatePath is watched, tmpl is reloaded when it's changed.
+	templatePath  string
+	templateSum   [sha256.Size]byte
+	tmpl          *template.Template
+	closeCh       chan bool
+	watchDuration time.Duration
+	// gcMaxWait is how long to wait for logs of a destroyed container to be
+	// read before its input is removed anyway, 0 means forever.
+	gcMaxWait      time.Duration
 	watchContainer map[string]*logStates
-	logger         log.Logger
-	lock           sync.Mutex
+	// containers are running containers, keyed by container ID.
+	containers map[string]*configurer.ContainerAddEvent
+	// growth tracks log growth rate of running containers.
+	growth map[string]*growth
+	// inputs are rendered inputs of running containers and containers
+	// waiting for GC, keyed by container ID.
+	inputs map[string]*renderedInput
+	// dirty are input files to write, values are container IDs.
+	dirty map[string]string
+	// writeDelay is how long to coalesce changes before writing them.
+	writeDelay time.Duration
+	flushTimer *time.Timer
+	// shards is the number of aggregated input files, 0 means a file per
+	// container.
+	shards     int
+	quarantine *configurer.QuarantineOptions
+	recorder   configurer.EventRecorder
+	logger     log.Logger
+	lock       sync.Mutex
 }
 
-// New creates a new filebeat configurer.
-func New(baseDir, configTemplateFile, filebeatHome string) (configurer.Configurer, error) {
-	t, err := template.ParseFiles(configTemplateFile)
-	if err != nil {
-		return nil, fmt.Errorf("error parse log template: %v", err)
+// New creates a new filebeat configurer. Runaway loggers are degraded if
+// quarantine is not nil. Degraded loggers and inputs removed before they are
+// read are reported by recorder, nil recorder drops the events.
+func New(baseDir string, cfg Config, quarantine *configurer.QuarantineOptions,
+	recorder configurer.EventRecorder) (configurer.Configurer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
 
-	if _, err := os.Stat(filebeatHome); err != nil {
+	if _, err := os.Stat(cfg.Home); err != nil {
 		return nil, err
 	}
 
-	logger := logp.NewLogger("configurer")
+	logger := log.NewLogger("configurer")
 	c := &filebeatConfigurer{
 		logger:         logger,
 		name:           "filebeat",
-		filebeatHome:   filebeatHome,
+		filebeatHome:   cfg.Home,
+		inputType:      cfg.InputType,
+		version:        cfg.Version,
 		base:           baseDir,
-		tmpl:           t,
+		templatePath:   cfg.Template,
 		closeCh:        make(chan bool),
 		watchContainer: make(map[string]*logStates, 0),
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		growth:         make(map[string]*growth),
+		inputs:         make(map[string]*renderedInput),
+		dirty:          make(map[string]string),
+		writeDelay:     cfg.WriteDelay,
+		shards:         cfg.Shards,
+		quarantine:     quarantine,
+		recorder:       recorder,
 		watchDuration:  60 * time.Second,
+		gcMaxWait:      cfg.GCMaxWait,
+	}
+
+	t, sum, err := c.loadTemplate(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("error parse log template: %v", err)
 	}
+	c.tmpl, c.templateSum = t, sum
 
 	if err := os.MkdirAll(c.getInputsDir(), 0644); err != nil {
 		return nil, err
 	}
+	if err := c.loadGCState(); err != nil {
+		return nil, fmt.Errorf("error load GC state: %v", err)
+	}
 
 	return c, nil
 }
@@ -78,6 +131,11 @@ func (c *filebeatConfigurer) Start() error {
 			c.logger.Errorf("error watch: %v", err)
 		}
 	}()
+	go func() {
+		if err := c.watchTemplate(); err != nil {
+			c.logger.Errorf("error watch template, it will not be reloaded: %v", err)
+		}
+	}()
 	return nil
 }
 
@@ -87,34 +145,37 @@ var configOpts = []ucfg.Option{
 	ucfg.VarExp,
 }
 
-// FileInode is copied from beats/filebeat/registar/registar.go
-type FileInode struct {
-	Inode  uint64 `json:"inode,"`
-	Device uint64 `json:"device,"`
-}
-
-// RegistryState is copied from beats/filebeat/registar/registar.go
-type RegistryState struct {
-	Source      string        `json:"source"`
-	Offset      int64         `json:"offset"`
-	Timestamp   time.Time     `json:"timestamp"`
-	TTL         time.Duration `json:"ttl"`
-	Type        string        `json:"type"`
-	FileStateOS FileInode
-}
-
 func (c *filebeatConfigurer) getInputsDir() string {
 	return filepath.Join(c.filebeatHome, "/inputs.d")
 }
 
-func (c *filebeatConfigurer) getRegistryFile() string {
-	return filepath.Join(c.filebeatHome, "data/registry")
-}
-
 // BootstrapCheck get called when we bootstrap. It removes unknown files,
-// update old version config to new version. And return all the input files.
+// update old version config to new version. And return all the input files
+// except those of destroyed containers waiting for GC.
 func (c *filebeatConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	format, err := detectRegistryFormat(c.getRegistryFile())
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Infof("Registry of filebeat %s is found", format)
+	if c.inputType == inputTypeFilestream && (format == registryV6 || format == registryV7) {
+		return nil, fmt.Errorf("filestream inputs require filebeat 7.14+, but filebeat is %s", format)
+	}
+
 	inputConfDir := c.getInputsDir()
+	removed, err := configurer.RemoveTempFiles(inputConfDir)
+	if err != nil {
+		return nil, fmt.Errorf("error remove temporary files: %v", err)
+	}
+	if len(removed) > 0 {
+		c.logger.Infof("Removed temporary files: %v", removed)
+	}
+	if err := c.loadShards(); err != nil {
+		return nil, fmt.Errorf("error load aggregated input files: %v", err)
+	}
 	files, err := ioutil.ReadDir(inputConfDir)
 	if err != nil {
 		return nil, err
@@ -124,19 +185,51 @@ func (c *filebeatConfigurer) BootstrapCheck() (map[string]*configurer.InputConfi
 	ret := make(map[string]*configurer.InputConfigFile)
 	for i := range files {
 		base := files[i].Name()
-		inputConfig, err := loadInput(base)
+		if files[i].IsDir() || isShardFile(base) {
+			continue
+		}
+		inputConfig, err := loadInput(filepath.Join(inputConfDir, base))
 		if err != nil {
 			log.Warnf("unable to load input config %s: %v", base, err)
 			toRemove = append(toRemove, base)
 			continue
 		}
-		// Just remove old version for now.
+		inputConfig.Path = filepath.Join(inputConfDir, base)
+		lst, watched := c.watchContainer[inputConfig.ContainerID]
 		if inputConfig.Version != currentInputConfigVersion {
-			log.Infof("old version: %s", base)
-			toRemove = append(toRemove, base)
+			c.logger.Infof("old version: %s", base)
+			var ev *configurer.ContainerAddEvent
+			if watched {
+				ev = lst.ev
+			}
+			if err := c.migrate(inputConfig, ev); err != nil {
+				return nil, fmt.Errorf("error migrate %s: %v", base, err)
+			}
+		}
+		if c.shards > 0 {
+			// Moved into aggregated files, the file is removed before
+			// they are written, so filebeat never sees an input twice.
+			data, err := ioutil.ReadFile(inputConfig.Path)
+			if err != nil {
+				return nil, err
+			}
+			c.claimLater(&renderedInput{
+				container: container.Container{
+					ID:        inputConfig.ContainerID,
+					Name:      inputConfig.Container,
+					Namespace: inputConfig.Namespace,
+					Pod:       inputConfig.Pod,
+					PodID:     inputConfig.PodID,
+				},
+				data: data,
+			})
+			toRemove = append(toRemove, filepath.Base(inputConfig.Path))
+			continue
+		}
+		// Inputs of destroyed containers are kept until GC finishes.
+		if watched {
 			continue
 		}
-		inputConfig.Path = filepath.Join(inputConfDir, base)
 		ret[inputConfig.ContainerID] = inputConfig
 	}
 
@@ -145,33 +238,10 @@ func (c *filebeatConfigurer) BootstrapCheck() (map[string]*configurer.InputConfi
 			return nil, err
 		}
 	}
-	return ret, nil
-}
-
-// <namespace>_<pod>_<container_name>_<container_id>_<version>.yml
-func loadInput(base string) (*configurer.InputConfigFile, error) {
-	if !strings.HasSuffix(base, ".yml") {
-		return nil, fmt.Errorf("filename does not end with .yml")
-	}
-	name := base[:len(base)-4]
-	items := strings.Split(name, "_")
-	if len(items) != 5 {
-		return nil, fmt.Errorf("invalid filename pattern: %v", name)
+	if err := c.flush(); err != nil {
+		return nil, fmt.Errorf("error write input configs: %v", err)
 	}
-
-	return &configurer.InputConfigFile{
-		Namespace:   items[0],
-		Pod:         items[1],
-		Container:   items[2],
-		ContainerID: items[3],
-		Version:     items[4],
-	}, nil
-}
-
-func (c *filebeatConfigurer) getContainerConfigPath(con *container.Container) string {
-	base := strings.Join([]string{con.Namespace, con.Pod, con.Name, con.ID, currentInputConfigVersion}, "_")
-	base = base + ".yml"
-	return filepath.Join(c.getInputsDir(), base)
+	return ret, nil
 }
 
 func (c *filebeatConfigurer) watch() error {
@@ -205,16 +275,17 @@ func (c *filebeatConfigurer) scan() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	c.detectRunaway(states, time.Now())
+
 	c.logger.Debugf("watching containers: %#v", c.watchContainer)
 
 	for container, lst := range c.watchContainer {
-		confPath := c.getContainerConfigPath(lst.Container)
-		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
+		if !c.hasInput(lst.Container) {
 			c.logger.Infof("log config %s.yml has been removed and ignore", container)
 			delete(c.watchContainer, container)
 		} else if c.canRemoveConf(container, states, lst) {
 			c.logger.Infof("try to remove log config %s.yml", container)
-			if err := os.Remove(confPath); err != nil {
+			if err := c.removeInput(container); err != nil {
 				c.logger.Errorf("remove log config %s.yml fail: %v", container, err)
 			} else {
 				delete(c.watchContainer, container)
@@ -223,6 +294,9 @@ func (c *filebeatConfigurer) scan() error {
 			c.logger.Debugf("%s.yml cannot be removed for now, will try to remove it in next scan", container)
 		}
 	}
+	if err := c.saveGCState(); err != nil {
+		c.logger.Errorf("error save GC state: %v", err)
+	}
 	return nil
 }
 
@@ -235,122 +309,150 @@ func getLogDirPrefix(base, podID string) string {
 }
 
 // 检查已删除容器 input 文件是否可以移除
-// 先根据用 pod id 生成唯一路径前缀，然后从 registry file 中找到相应的 states
-// 如果是第一次检查，更新 logStates 并返回 false
-// 否则和上一次检查对比，如果 states 有变化说明日志还没采集完, 更新 logStates 并返回 false，若没有变化则返回 true
+// 只有 registry 中的 offset 达到文件大小，即所有文件都已读到末尾时才可以移除
+// 如果已知容器的日志配置，检查配置的日志文件；否则根据 pod id 和容器 id 生成路径前缀，检查 registry 中相应的 states
+// 超过 gcMaxWait 仍未读完时强制移除，并报告未采集的字节数
 func (c *filebeatConfigurer) canRemoveConf(container string, registry map[string]RegistryState, lst *logStates) bool {
-	logDirPrefix := getLogDirPrefix(c.base, lst.PodID)
-	c.logger.Debug("LogDir prefix:", logDirPrefix)
-
-	// Find stats belong to the same pod
-	var states []RegistryState
-	for source, rs := range registry {
-		if strings.HasPrefix(source, logDirPrefix) {
-			c.logger.Debug("found match state:", source)
-			states = append(states, rs)
+	var lag int64
+	if lst.ev != nil {
+		_, lag = logSize(lst.ev, registry)
+		lst.states = lst.states[:0]
+		for _, cfg := range lst.ev.LogConfigs {
+			matches, _ := filepath.Glob(cfg.LogFile)
+			for _, f := range matches {
+				if rs, ok := registry[f]; ok {
+					lst.states = append(lst.states, rs)
+				}
+			}
 		}
+	} else {
+		lag = c.prefixLag(lst, registry)
+	}
+	if lag == 0 {
+		return true
 	}
 
-	// Sort states by source
-	bySource := func(i, j int) bool {
-		return states[i].Source < states[j].Source
+	waited := time.Since(lst.destroyed)
+	if c.gcMaxWait > 0 && waited > c.gcMaxWait {
+		msg := fmt.Sprintf("Input of container %s is removed after %v, %d bytes are not shipped",
+			container, waited, lag)
+		c.logger.Warn(msg)
+		c.recordEvent(&configurer.ContainerAddEvent{Container: *lst.Container}, eventTypeWarning,
+			reasonInputForceRemoved, msg)
+		return true
 	}
-	sort.Slice(states, bySource)
 
-	if zeroTime == lst.ts {
-		// First time check
-		c.logger.Debugf("check %s.yml for the first time, states: %#v", container, states)
-		lst.states = states
-		lst.ts = time.Now()
+	c.logger.Debugf("inputs for container %s cannot be removed for now, %d bytes are not read", container, lag)
+	return false
+}
 
-		if len(states) == 0 {
-			return true
-		}
-		return false
+// prefixLag finds states of the pod's emptyDir volumes and the container's
+// stdout, and returns how many bytes are not read.
+func (c *filebeatConfigurer) prefixLag(lst *logStates, registry map[string]RegistryState) int64 {
+	prefixes := []string{
+		getLogDirPrefix(c.base, lst.PodID),
+		filepath.Join(c.base, "/var/lib/docker/containers", lst.ID),
 	}
+	c.logger.Debug("LogDir prefixes:", prefixes)
 
-	c.logger.Debugf("check %s.yml, old states: %#v, new states: %#v", container, lst.states, states)
-
-	// Check if states changed
-	changed := false
-	if len(states) != len(lst.states) {
-		changed = true
-	} else {
-		for i := range states {
-			if states[i].Source == lst.states[i].Source &&
-				states[i].FileStateOS.Device == lst.states[i].FileStateOS.Device &&
-				states[i].FileStateOS.Inode == lst.states[i].FileStateOS.Inode &&
-				states[i].Offset == lst.states[i].Offset {
-				continue
-			} else {
-				changed = true
+	var states []RegistryState
+	for source, rs := range registry {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(source, prefix) {
+				c.logger.Debug("found match state:", source)
+				states = append(states, rs)
 				break
 			}
 		}
 	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Source < states[j].Source
+	})
+	lst.states = states
 
-	if changed == true {
-		// Update states, keep it and wait for next check
-		lst.states = states
-		lst.ts = time.Now()
-		c.logger.Debugf("inputs for container %s cannot be removed for now due to states changed")
-		return false
+	var lag int64
+	for _, rs := range states {
+		lag += stateLag(rs)
 	}
+	return lag
+}
 
-	return true
+// stateLag returns how many bytes of the file are not read. The file is
+// considered read if it's removed or replaced, since filebeat can't read
+// it any more.
+func stateLag(rs RegistryState) int64 {
+	fi, err := os.Stat(rs.Source)
+	if err != nil {
+		return 0
+	}
+	if inode, device := fileInode(fi); inode != rs.FileStateOS.Inode || device != rs.FileStateOS.Device {
+		return 0
+	}
+	if rs.Offset < fi.Size() {
+		return fi.Size() - rs.Offset
+	}
+	return 0
 }
 
 func (c *filebeatConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	c.containers[ev.Container.ID] = ev
+	// The container is running, e.g. claims its input in aggregated files.
+	if _, ok := c.watchContainer[ev.Container.ID]; ok {
+		delete(c.watchContainer, ev.Container.ID)
+		if err := c.saveGCState(); err != nil {
+			c.logger.Errorf("error save GC state: %v", err)
+		}
+	}
+	if g, ok := c.growth[ev.Container.ID]; ok && g.degraded {
+		return c.writeConfig(degrade(ev, c.quarantine))
+	}
+	return c.writeConfig(ev)
+}
+
+// writeConfig renders input config of the container, it's written after
+// writeDelay together with other changes.
+func (c *filebeatConfigurer) writeConfig(ev *configurer.ContainerAddEvent) error {
 	content, err := c.render(ev)
 	if err != nil {
 		return fmt.Errorf("error render config file: %v", err)
 	}
 
-	confPath := c.getContainerConfigPath(&ev.Container)
-	if err := ioutil.WriteFile(confPath, []byte(content), 0644); err != nil {
+	data, err := configurer.WithHeader(&ev.Container, currentInputConfigVersion, []byte(content))
+	if err != nil {
+		return fmt.Errorf("error encode header: %v", err)
+	}
+	if err := c.setInput(ev.Container, data); err != nil {
 		return fmt.Errorf("error write config file: %v", err)
 	}
-
-	c.logger.Info("Configuration updated successfully for container", ev.Container.ID)
+	c.logger.Debug("Configuration rendered for container", ev.Container.ID)
 	return nil
 }
 
 func (c *filebeatConfigurer) render(ev *configurer.ContainerAddEvent) (string, error) {
+	return c.renderWith(c.tmpl, ev)
+}
+
+func (c *filebeatConfigurer) renderWith(t *template.Template, ev *configurer.ContainerAddEvent) (string, error) {
 	var buf bytes.Buffer
+	configs, warnings := supportedConfigs(ev.LogConfigs, c.version)
+	for _, w := range warnings {
+		c.logger.Warnf("Container %s: %s", ev.Container.ID, w)
+	}
 	context := map[string]interface{}{
 		"containerId": ev.Container.ID,
-		"configList":  ev.LogConfigs,
+		"inputType":   c.inputType,
+		"configList":  configs,
 	}
-	if err := c.tmpl.Execute(&buf, context); err != nil {
+	if err := t.Execute(&buf, context); err != nil {
 		return "", err
 	}
-	return buf.String(), nil
-}
-
-func (c *filebeatConfigurer) getRegsitryState() (map[string]RegistryState, error) {
-	f, err := os.Open(c.getRegistryFile())
-	if err != nil {
-		return nil, err
+	if err := validateInputs(buf.Bytes()); err != nil {
+		return "", fmt.Errorf("invalid yaml: %v", err)
 	}
-	defer f.Close()
-
-	decoder := json.NewDecoder(f)
-	states := make([]RegistryState, 0)
-	err = decoder.Decode(&states)
-	if err != nil {
-		return nil, err
-	}
-
-	statesMap := make(map[string]RegistryState, 0)
-	for _, state := range states {
-		if _, ok := statesMap[state.Source]; !ok {
-			statesMap[state.Source] = state
-		}
-	}
-	return statesMap, nil
+	return buf.String(), nil
 }
 
 func (c *filebeatConfigurer) Name() string {
@@ -361,14 +463,33 @@ func (c *filebeatConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) err
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	added := c.containers[ev.Container.ID]
+	delete(c.containers, ev.Container.ID)
+	delete(c.growth, ev.Container.ID)
 	if _, ok := c.watchContainer[ev.Container.ID]; !ok {
 		c.watchContainer[ev.Container.ID] = &logStates{
 			Container: &ev.Container,
+			ev:        added,
+			destroyed: time.Now(),
+		}
+		if err := c.saveGCState(); err != nil {
+			c.logger.Errorf("error save GC state: %v", err)
 		}
 	}
 	return nil
 }
 
+// Stop writes pending changes and stops GC.
 func (c *filebeatConfigurer) Stop() {
 	close(c.closeCh)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+		c.flushTimer = nil
+	}
+	if err := c.flush(); err != nil {
+		c.logger.Errorf("error write input configs: %v", err)
+	}
 }
@@ -1,40 +1,34 @@
 package filebeat
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
-	"text/template"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"gopkg.in/yaml.v2"
 
 	"github.com/caicloud/log-pilot/pilot/container"
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
 )
 
-var (
-	expectRenderResult = `
-- type: log
-  enabled: true
-  paths:
-      - /opt/tomcat/access.log
-  scan_frequency: 10s
-  fields_under_root: true
-  fields:
-      foo: bar
-      tail_files: false
-  close_inactive: 2h
-  close_eof: false
-  close_removed: true
-  clean_removed: true
-  close_renamed: false`
-)
+// testTemplate is the template shipped in the image.
+const testTemplate = "../../../assets/filebeat/filebeat.tpl"
 
 func TestRender(t *testing.T) {
-	tmpl, err := template.ParseFiles("filebeat.tpl")
+	tmpl, _, err := (&filebeatConfigurer{}).loadTemplate(testTemplate)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	c := &filebeatConfigurer{
-		tmpl: tmpl,
+		tmpl:      tmpl,
+		inputType: inputTypeLog,
 	}
 	ev := configurer.ContainerAddEvent{
 		Container: container.Container{
@@ -46,11 +40,745 @@ func TestRender(t *testing.T) {
 				LogFile: "/opt/tomcat/access.log",
 				Format:  configurer.LogFormatPlain,
 				Tags:    map[string]string{"foo": "bar"},
+				InOpts:  map[string]string{"multiline": "true"},
+				OutOpts: map[string]string{configurer.OutOptIndex: "tomcat"},
+			},
+			&configurer.LogConfig{
+				Name:    "stdout",
+				LogFile: "/var/lib/docker/containers/1/1-json.log",
+				Format:  configurer.LogFormatJSON,
+				Stdout:  true,
+			},
+		},
+	}
+	content, err := c.render(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &inputs); err != nil {
+		t.Fatalf("invalid yaml: %v\n%s", err, content)
+	}
+	if len(inputs) != 2 || inputs[0]["type"] != inputTypeLog {
+		t.Fatalf("unexpected inputs: %v", inputs)
+	}
+	fields, _ := inputs[0]["fields"].(map[interface{}]interface{})
+	if fields["foo"] != "bar" || fields["multiline"] != "true" || fields[configurer.OutOptIndex] != "tomcat" {
+		t.Errorf("expect tags and options in fields, got %v", fields)
+	}
+	if _, ok := inputs[0]["json.keys_under_root"]; ok {
+		t.Errorf("expect plain log not decoded:\n%s", content)
+	}
+	if inputs[1]["json.keys_under_root"] != true || inputs[1]["docker-json"] == nil {
+		t.Errorf("expect json in docker envelope decoded:\n%s", content)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tmpl, err := parseTemplateData("test.tpl", []byte(
+		`- v: {{ quote .v }}
+  d: {{ .missing | default "unknown" }}
+  r: {{ regexEscape "a.b*" }}
+  t: {{ ternary "yes" "no" (hasKey .m "k") }}`), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	err = tmpl.Execute(&buf, map[string]interface{}{
+		"v":       "a: b\n# c \"d\"",
+		"missing": "",
+		"m":       map[string]string{"k": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []map[string]string
+	if err := yaml.Unmarshal([]byte(buf.String()), &out); err != nil {
+		t.Fatalf("invalid yaml %q: %v", buf.String(), err)
+	}
+	expect := map[string]string{"v": "a: b\n# c \"d\"", "d": "unknown", "r": `a\.b\*`, "t": "yes"}
+	if len(out) != 1 || !reflect.DeepEqual(out[0], expect) {
+		t.Errorf("expect %v, got %v", expect, out)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	for name, content := range map[string]string{
+		"field.tpl":   "{{range .configList}}- {{ .NoSuchField }}\n{{end}}",
+		"key.tpl":     "- id: {{ .noSuchKey }}\n",
+		"yaml.tpl":    "{{range .configList}}- paths: {{ .LogFile }}\n  x: - y\n{{end}}",
+		"inputs.tpl":  "type: log\n",
+		"unknown.tpl": "{{ noSuchFunc }}",
+	} {
+		writeFiles(t, home, map[string]string{name: content})
+		if _, err := New("/", Config{Template: filepath.Join(home, name), Home: home}, nil, nil); err == nil {
+			t.Errorf("%s: expect error", name)
+		}
+	}
+}
+
+func TestFormatProcessor(t *testing.T) {
+	cases := []struct {
+		cfg  configurer.LogConfig
+		kind string
+	}{
+		{configurer.LogConfig{Format: configurer.LogFormatPlain}, ""},
+		{configurer.LogConfig{Format: configurer.LogFormatJSON}, ""},
+		{configurer.LogConfig{Format: configurer.LogFormatNginx}, "dissect"},
+		{configurer.LogConfig{Format: configurer.LogFormatDissect, Pattern: "%{a} %{b}"}, "dissect"},
+		{configurer.LogConfig{Format: configurer.LogFormatLogfmt}, "script"},
+		{configurer.LogConfig{Format: configurer.LogFormatRegex, Pattern: `(?P<level>\w+) (?P<msg>.*)`}, "script"},
+	}
+
+	for _, cas := range cases {
+		p, err := formatProcessor(&cas.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cas.kind == "" {
+			if p != nil {
+				t.Errorf("expect no processor for %s, got %v", cas.cfg.Format, p)
+			}
+			continue
+		}
+		if _, ok := p[cas.kind]; !ok {
+			t.Errorf("expect %s processor for %s, got %v", cas.kind, cas.cfg.Format, p)
+		}
+	}
+
+	if _, err := formatProcessor(&configurer.LogConfig{Format: configurer.LogFormatRegex, Pattern: "("}); err == nil {
+		t.Error("expect error for invalid regex")
+	}
+}
+
+func TestProcessorsVersion(t *testing.T) {
+	cfg := &configurer.LogConfig{Name: "app", Format: configurer.LogFormatLogfmt}
+	for _, c := range []struct {
+		version string
+		ok      bool
+	}{
+		{"", true},
+		{"6.4.2", false},
+		{"7.0.1", false},
+		{"7.1.0", true},
+	} {
+		f := templateFuncs(c.version)["processors"].(func(*configurer.LogConfig) ([]processor, error))
+		if _, err := f(cfg); (err == nil) != c.ok {
+			t.Errorf("%q: expect ok %v, got %v", c.version, c.ok, err)
+		}
+	}
+
+	// dissect is supported by filebeat 6.x
+	f := templateFuncs("6.4.2")["processors"].(func(*configurer.LogConfig) ([]processor, error))
+	if _, err := f(&configurer.LogConfig{Format: configurer.LogFormatNginx}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSupportedConfigs(t *testing.T) {
+	tmpl, _, err := (&filebeatConfigurer{}).loadTemplate(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &filebeatConfigurer{tmpl: tmpl, version: "6.4.2", logger: logp.NewLogger("test")}
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{
+			{
+				Name:    "stdout",
+				LogFile: "/var/lib/docker/containers/1/1-json.log",
+				Format:  configurer.LogFormatLogfmt,
+				Level:   &configurer.LevelOptions{Min: "info"},
+			},
+			{
+				Name:       "secret",
+				LogFile:    "/var/log/secret.log",
+				Redactions: []configurer.RedactionRule{{Pattern: "password=\\S+"}},
 			},
 		},
 	}
-	_, err = c.render(&ev)
+	content, err := c.render(ev)
+	if err != nil {
+		t.Fatalf("expect unsupported features ignored, got %v", err)
+	}
+	if !strings.Contains(content, "1-json.log") || strings.Contains(content, "script") {
+		t.Errorf("expect stdout collected without scripts:\n%s", content)
+	}
+	if strings.Contains(content, "secret.log") {
+		t.Errorf("expect source with redaction skipped:\n%s", content)
+	}
+	if ev.LogConfigs[0].Level == nil {
+		t.Error("expect log config of the event untouched")
+	}
+}
+
+func TestTimestampProcessors(t *testing.T) {
+	ps, err := timestampProcessors(&configurer.TimestampOptions{
+		Pattern: `^(\S+)`,
+		Layouts: []string{"2006-01-02T15:04:05Z07:00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 3 {
+		t.Fatalf("expect capture, parse and check, got %v", ps)
+	}
+	ts := ps[1]["timestamp"].(map[string]interface{})
+	if ts["field"] != timestampTmpField || ts["target_field"] != timestampParsedField || ts["ignore_failure"] != true {
+		t.Errorf("unexpected timestamp processor: %v", ts)
+	}
+	// tagged by the result, so unmatched layouts are tagged too
+	source := ps[2]["script"].(map[string]interface{})["source"].(string)
+	if !strings.Contains(source, timestampFailureTag) || !strings.Contains(source, timestampParsedField) {
+		t.Errorf("unexpected check script: %s", source)
+	}
+}
+
+func TestLevelProcessors(t *testing.T) {
+	ps := levelProcessors(&configurer.LevelOptions{}, nil)
+	if len(ps) != 1 {
+		t.Fatalf("expect only detection, got %v", ps)
+	}
+	source := ps[0]["script"].(map[string]interface{})["source"].(string)
+	if !strings.Contains(source, `event.Put("level", level)`) || strings.Contains(source, "payload.") {
+		t.Errorf("expect level written to level: %s", source)
+	}
+
+	ps = levelProcessors(&configurer.LevelOptions{}, &configurer.JSONDecodeOptions{Target: "payload"})
+	source = ps[0]["script"].(map[string]interface{})["source"].(string)
+	if !strings.Contains(source, `["payload.level","payload.severity","payload.loglevel","level"`) {
+		t.Errorf("expect level read from json target first: %s", source)
+	}
+
+	ps = levelProcessors(&configurer.LevelOptions{Min: "info"}, nil)
+	if len(ps) != 2 {
+		t.Fatalf("expect detection and drop, got %v", ps)
+	}
+	drop := ps[1]["drop_event"].(map[string]interface{})
+	or := drop["when"].(map[string]interface{})["or"].([]interface{})
+	if len(or) != 2 {
+		t.Errorf("expect dropping trace and debug, got %v", or)
+	}
+}
+
+func TestRedactionProcessor(t *testing.T) {
+	ps, err := processors(&configurer.LogConfig{
+		Name:       "app",
+		JSONDecode: &configurer.JSONDecodeOptions{},
+		Redactions: []configurer.RedactionRule{{Pattern: "secret", Replacement: "***"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps[0]["decode_json_fields"] == nil || ps[1]["script"] == nil {
+		t.Fatalf("expect redaction after decoding, got %v", ps)
+	}
+	// all string fields are masked, not only message
+	if source := ps[1]["script"].(map[string]interface{})["source"].(string); !strings.Contains(source, "event.Get()") {
+		t.Errorf("expect redaction of all fields: %s", source)
+	}
+}
+
+func TestThrottleProcessor(t *testing.T) {
+	p := throttleProcessor(&configurer.ThrottleOptions{Sampling: map[string]float64{"debug": 0}})
+	source := p["script"].(map[string]interface{})["source"].(string)
+	// dropped lines are turned into summaries, so sampling by 0 is reported
+	if !strings.Contains(source, `"summaryInterval":10`) || !strings.Contains(source, "_throttle_summary") {
+		t.Errorf("expect summary events: %s", source)
+	}
+	if !strings.Contains(source, "utf8Length(msg)") {
+		t.Errorf("expect size counted in utf-8 bytes: %s", source)
+	}
+}
+
+type fakeRecorder struct {
+	reasons  []string
+	messages []string
+}
+
+func (r *fakeRecorder) Event(namespace, pod, podID, eventType, reason, message string) {
+	r.reasons = append(r.reasons, reason)
+	r.messages = append(r.messages, message)
+}
+
+func TestDetectRunaway(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	logFile := filepath.Join(home, "app.log")
+	if err := ioutil.WriteFile(logFile, make([]byte, 1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inode, device := fileInode(fi)
+	registry := map[string]RegistryState{
+		logFile: {Source: logFile, Offset: 1024, FileStateOS: FileInode{Inode: inode, Device: device}},
+	}
+
+	recorder := &fakeRecorder{}
+	c, err := New("/", Config{Template: testTemplate, Home: home}, &configurer.QuarantineOptions{
+		Threshold: 100,
+		Mode:      configurer.QuarantinePause,
+	}, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(*filebeatConfigurer)
+
+	ev := &configurer.ContainerAddEvent{
+		Container:  container.Container{ID: "1", Namespace: "default", Pod: "app", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: logFile}},
+	}
+	if err := fc.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	readConfig := func() string {
+		content, err := ioutil.ReadFile(fc.getContainerConfigPath(&ev.Container))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// Rate of the first scan is unknown
+	now := time.Now()
+	fc.detectRunaway(registry, now)
+	if strings.Contains(readConfig(), "script") {
+		t.Fatal("expect container not degraded on the first scan")
+	}
+
+	// 2048 bytes written in a minute, and not read yet
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Write(make([]byte, 2048))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	fc.detectRunaway(registry, now)
+	if !strings.Contains(readConfig(), "script") {
+		t.Fatalf("expect sampling script in degraded config, got %s", readConfig())
+	}
+	if len(recorder.messages) != 1 || !strings.Contains(recorder.messages[0], "2048 bytes not read") {
+		t.Errorf("unexpected events: %v", recorder.messages)
+	}
+
+	// Nothing written in the next minute
+	now = now.Add(time.Minute)
+	fc.detectRunaway(registry, now)
+	if strings.Contains(readConfig(), "script") {
+		t.Fatalf("expect container restored, got %s", readConfig())
+	}
+	if strings.Join(recorder.reasons, ",") != reasonRunawayLogger+","+reasonRunawayLoggerRecovered {
+		t.Errorf("unexpected events: %v", recorder.reasons)
+	}
+}
+
+func TestCanRemoveConf(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	logFile := filepath.Join(home, "app.log")
+	if err := ioutil.WriteFile(logFile, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inode, device := fileInode(fi)
+
+	recorder := &fakeRecorder{}
+	c, err := New("/", Config{Template: testTemplate, Home: home}, nil, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(*filebeatConfigurer)
+	con := container.Container{ID: "1", Namespace: "default", Pod: "app", Name: "app"}
+	lst := &logStates{
+		Container: &con,
+		ev: &configurer.ContainerAddEvent{
+			Container:  con,
+			LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: logFile}},
+		},
+		destroyed: time.Now(),
+	}
+	state := RegistryState{Source: logFile, Offset: 60, FileStateOS: FileInode{Inode: inode, Device: device}}
+
+	if fc.canRemoveConf("1", map[string]RegistryState{logFile: state}, lst) {
+		t.Error("expect input kept while the file is not read to the end")
+	}
+
+	state.Offset = 100
+	if !fc.canRemoveConf("1", map[string]RegistryState{logFile: state}, lst) {
+		t.Error("expect input removed after the file is read to the end")
+	}
+
+	state.Offset = 60
+	fc.gcMaxWait = time.Minute
+	lst.destroyed = time.Now().Add(-2 * time.Minute)
+	if !fc.canRemoveConf("1", map[string]RegistryState{logFile: state}, lst) {
+		t.Error("expect input removed after max wait")
+	}
+	if len(recorder.reasons) != 1 || recorder.reasons[0] != reasonInputForceRemoved {
+		t.Errorf("expect forced removal reported, got %v", recorder.reasons)
+	}
+}
+
+func TestRestoreGCState(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(home)
+
+	c, err := New("/", Config{Template: testTemplate, Home: home}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &configurer.ContainerAddEvent{
+		Container:  container.Container{ID: "1", Namespace: "default", Pod: "app", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: filepath.Join(home, "app.log")}},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: ev.Container}); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart
+	c, err = New("/", Config{Template: testTemplate, Home: home}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lst, ok := c.(*filebeatConfigurer).watchContainer["1"]
+	if !ok || lst.ev == nil || lst.ev.LogConfigs[0].Name != "app" {
+		t.Fatalf("expect container 1 restored, got %#v", lst)
+	}
+	files, err := c.BootstrapCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["1"]; ok {
+		t.Error("expect input of container waiting for GC not returned")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c, err := New("/", Config{Template: testTemplate, Home: home}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(*filebeatConfigurer)
+
+	var upgraded []string
+	registerMigration("v0.0", inputConfigVersionV0_1, func(c *filebeatConfigurer, info *configurer.InputConfigFile,
+		ev *configurer.ContainerAddEvent, to string) error {
+		upgraded = append(upgraded, info.ContainerID)
+		return retagInput(c, info, ev, to)
+	})
+	defer delete(migrations, "v0.0")
+
+	writeFiles(t, fc.getInputsDir(), map[string]string{
+		"default_app_app_1_v0.0.yml": "- type: log",
+		"default_app_app_2_v0.0.yml": "- type: log",
+	})
+	destroyed := container.Container{ID: "2", Namespace: "default", Pod: "app", Name: "app"}
+	fc.watchContainer["2"] = &logStates{Container: &destroyed}
+
+	files, err := c.BootstrapCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upgraded) != 2 {
+		t.Errorf("expect both files upgraded, got %v", upgraded)
+	}
+	if f, ok := files["1"]; !ok || f.Version != currentInputConfigVersion {
+		t.Errorf("expect input of container 1 migrated, got %v", files)
+	}
+	if _, ok := files["2"]; ok {
+		t.Error("expect input of destroyed container not returned")
+	}
+	if _, err := os.Stat(fc.getContainerConfigPath(&destroyed)); err != nil {
+		t.Errorf("expect input of destroyed container kept: %v", err)
+	}
+
+	// Files of a newer version are refused
+	writeFiles(t, fc.getInputsDir(), map[string]string{
+		"default_app_app_3_v9.9.yml": "- type: log",
+	})
+	if _, err := c.BootstrapCheck(); err == nil || !strings.Contains(err.Error(), "unknown version v9.9") {
+		t.Errorf("expect unknown version refused, got %v", err)
+	}
+}
+
+func TestLoadInput(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	c, err := New("/", Config{Template: testTemplate, Home: home}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(*filebeatConfigurer)
+
+	// docker container without kubernetes labels, and name with "_"
+	con := container.Container{ID: "1", Name: "my_app", PodID: "uid"}
+	if err := fc.OnAdd(&configurer.ContainerAddEvent{Container: con}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := loadInput(fc.getContainerConfigPath(&con))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ContainerID != "1" || info.Container != "my_app" || info.PodID != "uid" || info.Version != currentInputConfigVersion {
+		t.Errorf("unexpected identity: %#v", info)
+	}
+
+	// v0.1 file is migrated with body kept
+	writeFiles(t, fc.getInputsDir(), map[string]string{
+		"default_app_app_2_v0.1.yml": "- type: log\n",
+	})
+	files, err := c.BootstrapCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := files["2"]
+	if !ok || f.Version != currentInputConfigVersion || f.Pod != "app" {
+		t.Fatalf("expect v0.1 input migrated, got %v", files)
+	}
+	body, err := readInputBody(f.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "- type: log\n" {
+		t.Errorf("expect body kept, got %q", body)
+	}
+}
+
+func TestWriteDelayAndShards(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	cfg := Config{Template: testTemplate, Home: home, WriteDelay: time.Hour, Shards: 2}
+	c, err := New("/", cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(*filebeatConfigurer)
+	ids := []string{"1", "2", "3"}
+	for _, id := range ids {
+		ev := &configurer.ContainerAddEvent{Container: container.Container{ID: id, Name: "app"}}
+		if err := c.OnAdd(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := ioutil.ReadDir(fc.getInputsDir()); len(files) != 0 {
+		t.Fatalf("expect changes coalesced, got %d files", len(files))
+	}
+
+	// pending changes are written on stop
+	c.Stop()
+	files, err := ioutil.ReadDir(fc.getInputsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs int
+	for _, fi := range files {
+		if !isShardFile(fi.Name()) {
+			t.Fatalf("unexpected file %s", fi.Name())
+		}
+		data, err := ioutil.ReadFile(filepath.Join(fc.getInputsDir(), fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sections, err := splitInputs(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs += len(sections)
+	}
+	if len(files) > cfg.Shards || inputs != len(ids) {
+		t.Fatalf("expect %d inputs in at most %d files, got %d in %d", len(ids), cfg.Shards, inputs, len(files))
+	}
+
+	// inputs are watched until claimed by OnAdd after restart
+	c, err = New("/", cfg, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc = c.(*filebeatConfigurer)
+	if ret, err := c.BootstrapCheck(); err != nil || len(ret) != 0 {
+		t.Fatalf("expect no file returned, got %v, %v", ret, err)
+	}
+	if len(fc.watchContainer) != len(ids) || len(fc.inputs) != len(ids) {
+		t.Fatalf("expect inputs loaded and watched, got %v", fc.watchContainer)
+	}
+	if err := c.OnAdd(&configurer.ContainerAddEvent{Container: container.Container{ID: "1", Name: "app"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fc.watchContainer["1"]; ok {
+		t.Error("expect container 1 claimed")
+	}
+	if err := fc.removeInput("2"); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+
+	// aggregated files are split without shards
+	c, err = New("/", Config{Template: testTemplate, Home: home}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc = c.(*filebeatConfigurer)
+	ret, err := c.BootstrapCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 is kept for GC
+	if f, ok := ret["1"]; !ok || len(ret) != 1 || f.Path != fc.getInputPath("1") {
+		t.Fatalf("expect input of 1, got %v", ret)
+	}
+	if _, err := os.Stat(fc.getInputPath("3")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(fc.getInputPath("2")); !os.IsNotExist(err) {
+		t.Errorf("expect input of 2 removed, got %v", err)
+	}
+}
+
+func TestShardsDestroyedWhileDown(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	con := container.Container{ID: "1", Namespace: "default", Pod: "app", PodID: "uid", Name: "app"}
+	logDir := getLogDirPrefix(home, con.PodID)
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	logFile := filepath.Join(logDir, "logs", "app.log")
+	if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(logFile, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inode, device := fileInode(fi)
+	registry := map[string]RegistryState{
+		logFile: {Source: logFile, Offset: 60, FileStateOS: FileInode{Inode: inode, Device: device}},
+	}
+
+	c, err := New(home, Config{Template: testTemplate, Home: home}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.OnAdd(&configurer.ContainerAddEvent{Container: con}); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+
+	// The container is destroyed while log-pilot is down, its input is
+	// moved into aggregated files first, and loaded from them next time.
+	cfg := Config{Template: testTemplate, Home: home, WriteDelay: time.Hour, Shards: 2}
+	for i := 0; i < 2; i++ {
+		c, err = New(home, cfg, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fc := c.(*filebeatConfigurer)
+		if _, err := c.BootstrapCheck(); err != nil {
+			t.Fatal(err)
+		}
+		lst, ok := fc.watchContainer[con.ID]
+		if !ok || lst.PodID != con.PodID {
+			t.Fatalf("expect container watched with pod ID, got %+v", lst)
+		}
+		if fc.canRemoveConf(con.ID, registry, lst) {
+			t.Error("expect input kept while the emptyDir file is not read to the end")
+		}
+		c.Stop()
+	}
+}
+
+func TestReloadTemplate(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	tpl := filepath.Join(home, "input.tpl")
+	writeFiles(t, home, map[string]string{"input.tpl": "{{range .configList}}- paths: [{{ .LogFile }}]\n{{end}}"})
+
+	c, err := New("/", Config{Template: tpl, Home: home}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := c.(*filebeatConfigurer)
+	ev := &configurer.ContainerAddEvent{
+		Container:  container.Container{ID: "1", Name: "app"},
+		LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: "/app.log"}},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	readBody := func() string {
+		body, err := readInputBody(fc.getInputPath("1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	// fails to render, the old one is kept
+	writeFiles(t, home, map[string]string{"input.tpl": "{{range .configList}}{{ .NoSuchField }}{{end}}"})
+	if err := fc.reloadTemplate(); err == nil {
+		t.Fatal("expect error")
+	}
+	if body := readBody(); body != "- paths: [/app.log]\n" {
+		t.Fatalf("expect input unchanged, got %q", body)
+	}
+
+	writeFiles(t, home, map[string]string{"input.tpl": "{{range .configList}}- paths: [{{ .LogFile }}]\n  tail_files: true\n{{end}}"})
+	if err := fc.reloadTemplate(); err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(); body != "- paths: [/app.log]\n  tail_files: true\n" {
+		t.Fatalf("expect input rendered again, got %q", body)
+	}
 }
@@ -0,0 +1,127 @@
+package filebeat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+
+	"gopkg.in/yaml.v2"
+)
+
+// funcMap contains functions can be used in input template, processors is
+// bound to filebeat version by templateFuncs.
+var funcMap = template.FuncMap{
+	"processors":  processors,
+	"toYaml":      toYaml,
+	"indent":      indent,
+	"quote":       quote,
+	"default":     defaultValue,
+	"empty":       empty,
+	"ternary":     ternary,
+	"hasKey":      hasKey,
+	"regexEscape": regexp.QuoteMeta,
+}
+
+// templateFuncs returns funcMap whose processors fails if the processors
+// are not supported by filebeat of version, empty version skips the check.
+func templateFuncs(version string) template.FuncMap {
+	ret := make(template.FuncMap, len(funcMap))
+	for name, f := range funcMap {
+		ret[name] = f
+	}
+	ret["processors"] = func(cfg *configurer.LogConfig) ([]processor, error) {
+		ps, err := processors(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkVersion(ps, version); err != nil {
+			return nil, fmt.Errorf("log %s: %v", cfg.Name, err)
+		}
+		return ps, nil
+	}
+	return ret
+}
+
+// parseTemplateData parses content of input template file with functions
+// for filebeat of version.
+func parseTemplateData(path string, data []byte, version string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(templateFuncs(version)).Option("missingkey=error").Parse(string(data))
+}
+
+// toYaml encodes v as yaml without the trailing newline.
+func toYaml(v interface{}) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// indent adds n spaces to the beginning of every line of s.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// quote encodes v as a yaml double quoted string, so values contain ":",
+// "#" or newlines are safe.
+func quote(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fmt.Sprint(v)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// defaultValue returns d if v is empty, e.g. `{{ .Tags.app | default "unknown" }}`.
+func defaultValue(d, v interface{}) interface{} {
+	if empty(v) {
+		return d
+	}
+	return v
+}
+
+// empty reports whether v is nil, zero, or has no elements.
+func empty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return true
+	}
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return reflect.DeepEqual(v, reflect.Zero(rv.Type()).Interface())
+}
+
+// ternary returns a if cond is true, otherwise b, e.g.
+// `{{ ternary "true" "false" .Stdout }}`.
+func ternary(a, b interface{}, cond bool) interface{} {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// hasKey reports whether m contains key.
+func hasKey(m map[string]string, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+// validateInputs checks rendered content is a yaml list of inputs.
+func validateInputs(content []byte) error {
+	var inputs []map[string]interface{}
+	return yaml.Unmarshal(content, &inputs)
+}
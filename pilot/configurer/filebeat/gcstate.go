@@ -0,0 +1,81 @@
+package filebeat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+// gcStateFile keeps destroyed containers whose inputs are not removed yet,
+// so GC continues after log-pilot restarts.
+const gcStateFile = "log-pilot-gc.json"
+
+// pendingGC is a destroyed container in gcStateFile.
+type pendingGC struct {
+	Container  container.Container     `json:"container"`
+	LogConfigs []*configurer.LogConfig `json:"logConfigs,omitempty"`
+	States     []RegistryState         `json:"states,omitempty"`
+	Destroyed  time.Time               `json:"destroyed"`
+}
+
+func (c *filebeatConfigurer) getGCStateFile() string {
+	return filepath.Join(c.filebeatHome, gcStateFile)
+}
+
+// loadGCState restores watchContainer from gcStateFile.
+func (c *filebeatConfigurer) loadGCState() error {
+	data, err := ioutil.ReadFile(c.getGCStateFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var pending []pendingGC
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return err
+	}
+	for i := range pending {
+		p := &pending[i]
+		lst := &logStates{
+			Container: &p.Container,
+			states:    p.States,
+			destroyed: p.Destroyed,
+		}
+		if len(p.LogConfigs) > 0 {
+			lst.ev = &configurer.ContainerAddEvent{Container: p.Container, LogConfigs: p.LogConfigs}
+		}
+		c.watchContainer[p.Container.ID] = lst
+	}
+	c.logger.Infof("Restored %d containers waiting for GC", len(pending))
+	return nil
+}
+
+// saveGCState writes watchContainer to gcStateFile, the caller should hold
+// the lock.
+func (c *filebeatConfigurer) saveGCState() error {
+	pending := make([]pendingGC, 0, len(c.watchContainer))
+	for _, lst := range c.watchContainer {
+		p := pendingGC{
+			Container: *lst.Container,
+			States:    lst.states,
+			Destroyed: lst.destroyed,
+		}
+		if lst.ev != nil {
+			p.LogConfigs = lst.ev.LogConfigs
+		}
+		pending = append(pending, p)
+	}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+
+	_, err = configurer.WriteFileAtomic(c.getGCStateFile(), data, 0644)
+	return err
+}
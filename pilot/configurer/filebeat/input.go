@@ -0,0 +1,69 @@
+package filebeat
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+// getInputPath returns <inputs.d>/<hash of container ID>.yml.
+func (c *filebeatConfigurer) getInputPath(containerID string) string {
+	return filepath.Join(c.getInputsDir(), configurer.InputFileName(containerID, ".yml"))
+}
+
+func (c *filebeatConfigurer) getContainerConfigPath(con *container.Container) string {
+	return c.getInputPath(con.ID)
+}
+
+// loadInput reads identity of the input config file from its header, see
+// configurer.InputHeaderPrefix. Files of v0.1 have no header, identity is
+// parsed from the filename.
+func loadInput(path string) (*configurer.InputConfigFile, error) {
+	base := filepath.Base(path)
+	if !strings.HasSuffix(base, ".yml") {
+		return nil, fmt.Errorf("filename does not end with .yml")
+	}
+
+	info, err := configurer.LoadInput(path)
+	if err == configurer.ErrNoInputHeader {
+		return loadLegacyInput(base)
+	}
+	return info, err
+}
+
+// <namespace>_<pod>_<container_name>_<container_id>_<version>.yml
+func loadLegacyInput(base string) (*configurer.InputConfigFile, error) {
+	name := base[:len(base)-4]
+	items := strings.Split(name, "_")
+	if len(items) != 5 {
+		return nil, fmt.Errorf("invalid filename pattern: %v", name)
+	}
+
+	return &configurer.InputConfigFile{
+		Namespace:   items[0],
+		Pod:         items[1],
+		Container:   items[2],
+		ContainerID: items[3],
+		Version:     items[4],
+	}, nil
+}
+
+// readInputBody returns content of the input config file without header.
+func readInputBody(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(configurer.InputHeaderPrefix)) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			return data[i+1:], nil
+		}
+		return nil, nil
+	}
+	return data, nil
+}
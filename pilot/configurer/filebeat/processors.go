@@ -0,0 +1,534 @@
+package filebeat
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+// processor is a filebeat processor definition, e.g.
+// {"dissect": {"tokenizer": "...", "field": "message"}}
+type processor map[string]interface{}
+
+// dissectPatterns are tokenizers for built-in formats which can be parsed
+// by filebeat dissect processor.
+var dissectPatterns = map[configurer.LogFormat]string{
+	configurer.LogFormatCRI:           `%{time} %{stream} %{flags} %{message}`,
+	configurer.LogFormatSyslog:        `<%{priority}>%{timestamp} %{+timestamp} %{+timestamp} %{hostname} %{program}: %{message}`,
+	configurer.LogFormatSyslogRFC5424: `<%{priority}>%{version} %{timestamp} %{hostname} %{app_name} %{procid} %{msgid} %{structured_data} %{message}`,
+	configurer.LogFormatNginx:         `%{remote_addr} - %{remote_user} [%{time_local}] "%{method} %{request} %{protocol}" %{status} %{body_bytes_sent} "%{http_referer}" "%{http_user_agent}"`,
+	configurer.LogFormatApache:        `%{client} %{ident} %{auth} [%{timestamp}] "%{verb} %{request} %{httpversion}" %{response} %{bytes} "%{referrer}" "%{agent}"`,
+}
+
+// dissectPrefixes are the keys parsed fields put under.
+var dissectPrefixes = map[configurer.LogFormat]string{
+	configurer.LogFormatCRI:           "cri",
+	configurer.LogFormatSyslog:        "syslog",
+	configurer.LogFormatSyslogRFC5424: "syslog",
+	configurer.LogFormatNginx:         "nginx",
+	configurer.LogFormatApache:        "apache",
+	configurer.LogFormatDissect:       "dissect",
+}
+
+const logfmtScript = `function process(event) {
+    var msg = event.Get("message");
+    if (typeof msg !== "string") {
+        return;
+    }
+    var re = /([^\s=]+)=(?:"((?:[^"\\]|\\.)*)"|(\S*))/g;
+    var m;
+    while ((m = re.exec(msg)) !== null) {
+        event.Put("logfmt." + m[1], m[2] !== undefined ? m[2] : m[3]);
+    }
+}`
+
+const regexScript = `var re = new RegExp(%s);
+var names = %s;
+function process(event) {
+    var msg = event.Get("message");
+    var m = typeof msg === "string" ? re.exec(msg) : null;
+    if (m === null) {
+        event.Tag("_regex_parse_failure");
+        return;
+    }
+    for (var i = 0; i < names.length; i++) {
+        if (names[i] !== "" && m[i + 1] !== undefined) {
+            event.Put("regex." + names[i], m[i + 1]);
+        }
+    }
+}`
+
+// timestampResultScript moves the parsed timestamp to @timestamp, the event
+// is tagged if the field is missing or matches none of the layouts.
+const timestampResultScript = `var parsed = %q, tmp = %q, tag = %q;
+function process(event) {
+    var ts = event.Get(parsed);
+    if (tmp !== "") {
+        event.Delete(tmp);
+    }
+    if (ts === null || ts === undefined) {
+        event.Tag(tag);
+        return;
+    }
+    event.Put("@timestamp", ts);
+    event.Delete(parsed);
+}`
+
+const timestampScript = `var re = new RegExp(%s);
+function process(event) {
+    var msg = event.Get("message");
+    var m = typeof msg === "string" ? re.exec(msg) : null;
+    if (m !== null && m[1] !== undefined) {
+        event.Put(%q, m[1]);
+    }
+}`
+
+// levelScript finds level in parsed fields first, then in the message.
+const levelScript = `var aliases = {
+    "trace": "trace", "trc": "trace", "finest": "trace",
+    "debug": "debug", "dbg": "debug", "fine": "debug",
+    "info": "info", "inf": "info", "information": "info", "notice": "info",
+    "warn": "warn", "warning": "warn", "wrn": "warn",
+    "error": "error", "err": "error", "eror": "error", "severe": "error",
+    "fatal": "fatal", "ftl": "fatal", "critical": "fatal", "crit": "fatal", "panic": "fatal", "emerg": "fatal", "alert": "fatal"
+};
+var fields = %s;
+var patterns = [
+    /\blevel=["']?(\w+)/i,
+    /\[(trace|debug|info|warn|warning|error|fatal|critical|panic)\]/i,
+    /"(?:severity|level)"\s*:\s*"(\w+)"/i
+];
+function process(event) {
+    var raw = null;
+    for (var i = 0; i < fields.length && raw === null; i++) {
+        var v = event.Get(fields[i]);
+        if (typeof v === "string") {
+            raw = v;
+        }
+    }
+    var msg = event.Get("message");
+    for (var j = 0; j < patterns.length && raw === null && typeof msg === "string"; j++) {
+        var m = patterns[j].exec(msg);
+        if (m !== null) {
+            raw = m[1];
+        }
+    }
+    if (raw === null) {
+        return;
+    }
+    var level = aliases[raw.toLowerCase()];
+    if (level !== undefined) {
+        event.Put(%q, level);
+    }
+}`
+
+// redactionScript masks every string field of the event, so fields decoded
+// from message are masked as well as message itself.
+const redactionScript = `var rules = %s;
+for (var i = 0; i < rules.length; i++) {
+    rules[i].re = new RegExp(rules[i].pattern, rules[i].flags);
+}
+function redact(event, prefix, obj) {
+    for (var key in obj) {
+        var value = obj[key];
+        var path = prefix + key;
+        if (typeof value === "string") {
+            var masked = value;
+            for (var i = 0; i < rules.length; i++) {
+                masked = masked.replace(rules[i].re, rules[i].replacement);
+            }
+            if (masked !== value) {
+                event.Put(path, masked);
+            }
+        } else if (value !== null && typeof value === "object" && !Array.isArray(value)) {
+            redact(event, path + ".", value);
+        }
+    }
+}
+function process(event) {
+    redact(event, "", event.Get());
+}`
+
+// throttleScript drops lines by sampling and token buckets. The script
+// processor can't create events, so a dropped line is turned into a summary
+// of lines dropped since the last summary, at most once per interval.
+const throttleScript = `var opts = %s;
+var levelField = %q;
+var lineTokens = opts.linesBurst;
+var byteTokens = opts.bytesBurst;
+var last = Date.now();
+var lastSummary = 0;
+var sampled = 0, dropped = 0, droppedBytes = 0;
+function utf8Length(s) {
+    var n = 0;
+    for (var i = 0; i < s.length; i++) {
+        var c = s.charCodeAt(i);
+        if (c < 0x80) {
+            n += 1;
+        } else if (c < 0x800) {
+            n += 2;
+        } else if (c >= 0xD800 && c < 0xDC00 && i + 1 < s.length) {
+            n += 4;
+            i++;
+        } else {
+            n += 3;
+        }
+    }
+    return n;
+}
+function drop(event, now) {
+    if (now - lastSummary < opts.summaryInterval * 1000) {
+        event.Cancel();
+        return;
+    }
+    lastSummary = now;
+    event.Put("message", "throttled " + (sampled + dropped) + " lines");
+    event.Delete(levelField);
+    event.Put("throttle.sampled_lines", sampled);
+    event.Put("throttle.dropped_lines", dropped);
+    event.Put("throttle.dropped_bytes", droppedBytes);
+    event.Tag("_throttle_summary");
+    sampled = 0;
+    dropped = 0;
+    droppedBytes = 0;
+}
+function process(event) {
+    var msg = event.Get("message");
+    var size = typeof msg === "string" ? utf8Length(msg) : 0;
+    var now = Date.now();
+
+    var level = event.Get(levelField);
+    var ratio = opts.sampling[level];
+    if (ratio === undefined) {
+        ratio = opts.sampling["*"];
+    }
+    if (ratio !== undefined && Math.random() >= ratio) {
+        sampled++;
+        drop(event, now);
+        return;
+    }
+
+    var elapsed = (now - last) / 1000;
+    last = now;
+    lineTokens = Math.min(opts.linesBurst, lineTokens + elapsed * opts.linesPerSecond);
+    byteTokens = Math.min(opts.bytesBurst, byteTokens + elapsed * opts.bytesPerSecond);
+    if ((opts.linesPerSecond > 0 && lineTokens < 1) || (opts.bytesPerSecond > 0 && byteTokens < size)) {
+        dropped++;
+        droppedBytes += size;
+        drop(event, now);
+        return;
+    }
+    lineTokens -= 1;
+    byteTokens -= size;
+}`
+
+// logLevelField holds the normalized level. message is renamed to log by
+// build/filebeat/filebeat.yml.tpl, so log.level can not be used, and the raw
+// level decoded to the top level is replaced.
+const logLevelField = "level"
+
+// levelFields are fields the level is read from, before the message.
+var levelFields = []string{"level", "severity", "loglevel"}
+
+const (
+	// timestampTmpField holds the timestamp captured by pattern.
+	timestampTmpField = "_timestamp"
+	// timestampParsedField holds the result of timestamp processor, it's
+	// missing if the timestamp can not be parsed.
+	timestampParsedField = "_timestamp_parsed"
+	// timestampFailureTag is added when timestamp can not be extracted.
+	timestampFailureTag = "_timestamp_parse_failure"
+)
+
+// minVersions are filebeat versions processors are introduced in, processors
+// not listed are supported by filebeat 6.x.
+var minVersions = map[string][2]int{
+	"timestamp": {7, 0},
+	"script":    {7, 1},
+	"add_tags":  {7, 2},
+}
+
+// checkVersion returns error if any processor is not supported by filebeat
+// of version, empty version skips the check.
+func checkVersion(ps []processor, version string) error {
+	if version == "" {
+		return nil
+	}
+	major, minor, err := parseVersion(version)
+	if err != nil {
+		return err
+	}
+	for _, p := range ps {
+		for kind := range p {
+			min, ok := minVersions[kind]
+			if ok && (major < min[0] || major == min[0] && minor < min[1]) {
+				return fmt.Errorf("%s processor requires filebeat %d.%d+, got %s", kind, min[0], min[1], version)
+			}
+		}
+	}
+	return nil
+}
+
+// supportedConfigs returns copies of cfgs without features unsupported by
+// filebeat of version, so the rest of the sources are still collected.
+// Sources whose redactions are unsupported are skipped, they must not be
+// shipped unmasked. Warnings tell what are dropped.
+func supportedConfigs(cfgs []*configurer.LogConfig, version string) ([]*configurer.LogConfig, []string) {
+	if version == "" {
+		return cfgs, nil
+	}
+	var (
+		ret      []*configurer.LogConfig
+		warnings []string
+	)
+	unsupported := func(cfg *configurer.LogConfig, feature string, ps ...processor) bool {
+		err := checkVersion(ps, version)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s of log %s is ignored: %v", feature, cfg.Name, err))
+		}
+		return err != nil
+	}
+	for _, cfg := range cfgs {
+		c := *cfg
+		if len(c.Redactions) > 0 {
+			if err := checkVersion([]processor{redactionProcessor(c.Redactions)}, version); err != nil {
+				warnings = append(warnings, fmt.Sprintf("log %s is not collected, redaction is unsupported: %v", c.Name, err))
+				continue
+			}
+		}
+		if p, err := formatProcessor(&c); err == nil && p != nil && unsupported(&c, "format "+string(c.Format), p) {
+			c.Format, c.Pattern = configurer.LogFormatPlain, ""
+		}
+		if c.Timestamp != nil {
+			if ps, err := timestampProcessors(c.Timestamp); err == nil && unsupported(&c, "timestamp", ps...) {
+				c.Timestamp = nil
+			}
+		}
+		if c.Level != nil && unsupported(&c, "level", levelProcessors(c.Level, c.JSONDecode)...) {
+			c.Level = nil
+		}
+		if c.Throttle != nil && unsupported(&c, "throttle", throttleProcessor(c.Throttle)) {
+			c.Throttle = nil
+		}
+		ret = append(ret, &c)
+	}
+	return ret, warnings
+}
+
+// namedGroup matches the go style named group, javascript RegExp doesn't
+// support it.
+var namedGroup = regexp.MustCompile(`\(\?P<[^>]+>`)
+
+// processors generates filebeat processors for a log config.
+func processors(cfg *configurer.LogConfig) ([]processor, error) {
+	var ret []processor
+
+	p, err := formatProcessor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		ret = append(ret, p)
+	}
+	if cfg.JSONDecode != nil {
+		ret = append(ret, jsonDecodeProcessor(cfg.JSONDecode))
+	}
+	// Redaction goes after decoding, content escaped in json is only visible
+	// in decoded fields.
+	if len(cfg.Redactions) > 0 {
+		ret = append(ret, redactionProcessor(cfg.Redactions))
+	}
+	if cfg.Timestamp != nil {
+		ps, err := timestampProcessors(cfg.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ps...)
+	}
+	if cfg.Level != nil {
+		ret = append(ret, levelProcessors(cfg.Level, cfg.JSONDecode)...)
+	}
+	if cfg.Throttle != nil {
+		ret = append(ret, throttleProcessor(cfg.Throttle))
+	}
+
+	return ret, nil
+}
+
+// formatProcessor returns processor which parses log lines by format.
+// logfmt and regex rely on script processor which requires filebeat 7.1+.
+func formatProcessor(cfg *configurer.LogConfig) (processor, error) {
+	switch cfg.Format {
+	case configurer.LogFormatCRI, configurer.LogFormatSyslog, configurer.LogFormatSyslogRFC5424,
+		configurer.LogFormatNginx, configurer.LogFormatApache:
+		return dissectProcessor(dissectPatterns[cfg.Format], dissectPrefixes[cfg.Format]), nil
+	case configurer.LogFormatDissect:
+		if cfg.Pattern == "" {
+			return nil, fmt.Errorf("empty dissect pattern of %s", cfg.Name)
+		}
+		return dissectProcessor(cfg.Pattern, dissectPrefixes[cfg.Format]), nil
+	case configurer.LogFormatLogfmt:
+		return scriptProcessor(logfmtScript), nil
+	case configurer.LogFormatRegex:
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern of %s: %v", cfg.Name, err)
+		}
+		pattern, _ := json.Marshal(namedGroup.ReplaceAllString(cfg.Pattern, "("))
+		names, _ := json.Marshal(re.SubexpNames()[1:])
+		return scriptProcessor(fmt.Sprintf(regexScript, pattern, names)), nil
+	}
+	return nil, nil
+}
+
+// jsonDecodeProcessor decodes json in message, message is kept untouched if
+// it's not a json object.
+func jsonDecodeProcessor(opts *configurer.JSONDecodeOptions) processor {
+	return processor{
+		"decode_json_fields": map[string]interface{}{
+			"fields":         []string{"message"},
+			"target":         opts.Target,
+			"overwrite_keys": opts.OverwriteKeys,
+			"add_error_key":  opts.AddErrorKey,
+			"process_array":  false,
+			"max_depth":      1,
+		},
+	}
+}
+
+// timestampProcessors parses timestamp to @timestamp. The result is written
+// to timestampParsedField first, so timestampFailureTag is added whether the
+// field is missing or none of the layouts matches.
+func timestampProcessors(opts *configurer.TimestampOptions) ([]processor, error) {
+	var ret []processor
+
+	field := opts.Field
+	if opts.Pattern != "" {
+		if _, err := regexp.Compile(opts.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid timestamp pattern: %v", err)
+		}
+		pattern, _ := json.Marshal(namedGroup.ReplaceAllString(opts.Pattern, "("))
+		ret = append(ret, scriptProcessor(fmt.Sprintf(timestampScript, pattern, timestampTmpField)))
+		field = timestampTmpField
+	}
+
+	timestamp := map[string]interface{}{
+		"field":          field,
+		"target_field":   timestampParsedField,
+		"layouts":        opts.Layouts,
+		"ignore_missing": true,
+		"ignore_failure": true,
+	}
+	if opts.Timezone != "" {
+		timestamp["timezone"] = opts.Timezone
+	}
+	ret = append(ret, processor{"timestamp": timestamp})
+
+	tmp := ""
+	if field == timestampTmpField {
+		tmp = timestampTmpField
+	}
+	ret = append(ret, scriptProcessor(fmt.Sprintf(timestampResultScript, timestampParsedField, tmp, timestampFailureTag)))
+	return ret, nil
+}
+
+// levelProcessors detects level to logLevelField, and drops lines whose level
+// is lower than opts.Min. Lines without a level are kept. Fields decoded
+// under the target of jsonDecode are read first.
+func levelProcessors(opts *configurer.LevelOptions, jsonDecode *configurer.JSONDecodeOptions) []processor {
+	var fields []string
+	if jsonDecode != nil && jsonDecode.Target != "" {
+		for _, f := range levelFields {
+			fields = append(fields, jsonDecode.Target+"."+f)
+		}
+	}
+	data, _ := json.Marshal(append(fields, levelFields...))
+	ret := []processor{scriptProcessor(fmt.Sprintf(levelScript, data, logLevelField))}
+
+	var lower []interface{}
+	for _, l := range configurer.LogLevels {
+		if l == opts.Min {
+			break
+		}
+		lower = append(lower, map[string]interface{}{
+			"equals": map[string]interface{}{logLevelField: l},
+		})
+	}
+	if opts.Min == "" || len(lower) == 0 {
+		return ret
+	}
+
+	return append(ret, processor{
+		"drop_event": map[string]interface{}{
+			"when": map[string]interface{}{
+				"or": lower,
+			},
+		},
+	})
+}
+
+// redactionProcessor masks string fields with rules in order.
+func redactionProcessor(rules []configurer.RedactionRule) processor {
+	type jsRule struct {
+		Pattern     string `json:"pattern"`
+		Flags       string `json:"flags"`
+		Replacement string `json:"replacement"`
+	}
+	var jsRules []jsRule
+	for _, r := range rules {
+		flags := "g"
+		if r.IgnoreCase {
+			flags += "i"
+		}
+		jsRules = append(jsRules, jsRule{
+			Pattern:     namedGroup.ReplaceAllString(r.Pattern, "("),
+			Flags:       flags,
+			Replacement: r.Replacement,
+		})
+	}
+	data, _ := json.Marshal(jsRules)
+	return scriptProcessor(fmt.Sprintf(redactionScript, data))
+}
+
+// throttleSummaryInterval is the minimal interval between summaries of
+// throttled lines.
+const throttleSummaryInterval = 10 * time.Second
+
+// throttleProcessor limits rate of a log source.
+func throttleProcessor(opts *configurer.ThrottleOptions) processor {
+	sampling := opts.Sampling
+	if sampling == nil {
+		sampling = map[string]float64{}
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"linesPerSecond":  opts.LinesPerSecond,
+		"linesBurst":      opts.LinesBurst,
+		"bytesPerSecond":  opts.BytesPerSecond,
+		"bytesBurst":      opts.BytesBurst,
+		"sampling":        sampling,
+		"summaryInterval": throttleSummaryInterval.Seconds(),
+	})
+	return scriptProcessor(fmt.Sprintf(throttleScript, data, logLevelField))
+}
+
+func dissectProcessor(tokenizer, prefix string) processor {
+	return processor{
+		"dissect": map[string]interface{}{
+			"tokenizer":     tokenizer,
+			"field":         "message",
+			"target_prefix": prefix,
+		},
+	}
+}
+
+func scriptProcessor(source string) processor {
+	return processor{
+		"script": map[string]interface{}{
+			"lang":   "javascript",
+			"source": source,
+		},
+	}
+}
@@ -0,0 +1,171 @@
+package filebeat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+const (
+	reasonRunawayLogger          = "RunawayLogger"
+	reasonRunawayLoggerRecovered = "RunawayLoggerRecovered"
+	reasonInputForceRemoved      = "InputForceRemoved"
+
+	eventTypeNormal  = "Normal"
+	eventTypeWarning = "Warning"
+)
+
+// growth tracks the log growth rate of a container.
+type growth struct {
+	size int64
+	ts   time.Time
+	// overSince is when the rate exceeded threshold, zero if it's below.
+	overSince time.Time
+	// underSince is when the rate dropped below threshold in degraded mode.
+	underSince time.Time
+	degraded   bool
+}
+
+// logSize returns total size of log files of the container, and how many
+// bytes are not read by filebeat yet according to the registry.
+func logSize(ev *configurer.ContainerAddEvent, registry map[string]RegistryState) (size, lag int64) {
+	for _, cfg := range ev.LogConfigs {
+		matches, err := filepath.Glob(cfg.LogFile)
+		if err != nil {
+			continue
+		}
+		for _, f := range matches {
+			fi, err := os.Stat(f)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			size += fi.Size()
+			inode, device := fileInode(fi)
+			if rs, ok := registry[f]; !ok || rs.FileStateOS.Inode != inode || rs.FileStateOS.Device != device {
+				lag += fi.Size()
+			} else if rs.Offset < fi.Size() {
+				lag += fi.Size() - rs.Offset
+			}
+		}
+	}
+	return size, lag
+}
+
+// detectRunaway measures log growth rate of running containers. Containers
+// whose rate stays above threshold for the window are degraded, and restored
+// after the rate stays below threshold for the window. now is when the
+// registry and log files are read.
+func (c *filebeatConfigurer) detectRunaway(registry map[string]RegistryState, now time.Time) {
+	opts := c.quarantine
+	if opts == nil || opts.Threshold <= 0 {
+		return
+	}
+
+	for id, ev := range c.containers {
+		size, lag := logSize(ev, registry)
+		g, ok := c.growth[id]
+		if !ok {
+			c.growth[id] = &growth{size: size, ts: now}
+			continue
+		}
+
+		written := size - g.size
+		if written < 0 {
+			// Rotated or truncated
+			written = size
+		}
+		rate := float64(written) / now.Sub(g.ts).Minutes()
+		g.size, g.ts = size, now
+		c.logger.Debugf("container %s log grows %.0f bytes/min, %d bytes not read", id, rate, lag)
+
+		if rate > float64(opts.Threshold) {
+			g.underSince = zeroTime
+			if g.overSince == zeroTime {
+				g.overSince = now
+			}
+			if g.degraded || now.Sub(g.overSince) < opts.Window {
+				continue
+			}
+			msg := fmt.Sprintf("Log grows %.0f bytes/min, above threshold %d bytes/min for %v, %d bytes not read, collecting is degraded to %s",
+				rate, opts.Threshold, now.Sub(g.overSince), lag, opts.Mode)
+			if err := c.writeConfig(degrade(ev, opts)); err != nil {
+				c.logger.Errorf("error degrade container %s: %v", id, err)
+				continue
+			}
+			g.degraded = true
+			c.logger.Warnf("container %s: %s", id, msg)
+			c.recordEvent(ev, eventTypeWarning, reasonRunawayLogger, msg)
+		} else {
+			g.overSince = zeroTime
+			if !g.degraded {
+				continue
+			}
+			if g.underSince == zeroTime {
+				g.underSince = now
+			}
+			if now.Sub(g.underSince) < opts.Window {
+				continue
+			}
+			msg := fmt.Sprintf("Log grows %.0f bytes/min, below threshold %d bytes/min for %v, collecting is restored",
+				rate, opts.Threshold, now.Sub(g.underSince))
+			if err := c.writeConfig(ev); err != nil {
+				c.logger.Errorf("error restore container %s: %v", id, err)
+				continue
+			}
+			g.degraded = false
+			g.underSince = zeroTime
+			c.logger.Infof("container %s: %s", id, msg)
+			c.recordEvent(ev, eventTypeNormal, reasonRunawayLoggerRecovered, msg)
+		}
+	}
+}
+
+func (c *filebeatConfigurer) recordEvent(ev *configurer.ContainerAddEvent, eventType, reason, msg string) {
+	if c.recorder == nil || ev.Container.Pod == "" {
+		return
+	}
+	con := ev.Container
+	c.recorder.Event(con.Namespace, con.Pod, con.PodID, eventType, reason, msg)
+}
+
+// degrade returns a copy of ev whose log configs are limited by opts.Mode.
+func degrade(ev *configurer.ContainerAddEvent, opts *configurer.QuarantineOptions) *configurer.ContainerAddEvent {
+	ret := &configurer.ContainerAddEvent{
+		Container: ev.Container,
+	}
+	for _, cfg := range ev.LogConfigs {
+		copied := *cfg
+		switch opts.Mode {
+		case configurer.QuarantineDropDebug:
+			if cfg.Level == nil || levelIndex(cfg.Level.Min) < levelIndex("info") {
+				copied.Level = &configurer.LevelOptions{Min: "info"}
+			}
+		case configurer.QuarantineSample, configurer.QuarantinePause:
+			ratio := opts.SampleRatio
+			if opts.Mode == configurer.QuarantinePause {
+				ratio = 0
+			}
+			throttle := &configurer.ThrottleOptions{}
+			if cfg.Throttle != nil {
+				*throttle = *cfg.Throttle
+			}
+			throttle.Sampling = map[string]float64{"*": ratio}
+			copied.Throttle = throttle
+		}
+		ret.LogConfigs = append(ret.LogConfigs, &copied)
+	}
+	return ret
+}
+
+// levelIndex returns index of l in configurer.LogLevels, -1 if not found.
+func levelIndex(l string) int {
+	for i, lv := range configurer.LogLevels {
+		if lv == l {
+			return i
+		}
+	}
+	return -1
+}
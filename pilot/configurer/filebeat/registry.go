@@ -0,0 +1,309 @@
+package filebeat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileInode is copied from beats/filebeat/registar/registar.go
+type FileInode struct {
+	Inode  uint64 `json:"inode,"`
+	Device uint64 `json:"device,"`
+}
+
+// RegistryState is copied from beats/filebeat/registar/registar.go
+type RegistryState struct {
+	Source      string        `json:"source"`
+	Offset      int64         `json:"offset"`
+	Timestamp   time.Time     `json:"timestamp"`
+	TTL         time.Duration `json:"ttl"`
+	Type        string        `json:"type"`
+	FileStateOS FileInode
+}
+
+// fileInode returns inode and device of the file.
+func fileInode(fi os.FileInfo) (inode, device uint64) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino), uint64(st.Dev)
+	}
+	return 0, 0
+}
+
+// registryFormat is the layout of filebeat registry, it tells which
+// version of filebeat is running.
+type registryFormat int
+
+const (
+	registryUnknown registryFormat = iota
+	// registryV6 is a json array in data/registry, used by filebeat 6.x.
+	registryV6
+	// registryV7 is a json array in data/registry/filebeat/data.json, used
+	// by filebeat 7.0 - 7.8.
+	registryV7
+	// registryMemlog is checkpoints and a log of updates in
+	// data/registry/filebeat, used since filebeat 7.9.
+	registryMemlog
+)
+
+func (f registryFormat) String() string {
+	switch f {
+	case registryV6:
+		return "6.x"
+	case registryV7:
+		return "7.0-7.8"
+	case registryMemlog:
+		return "7.9+"
+	}
+	return "unknown"
+}
+
+// Keys of states in memlog registry.
+const (
+	memlogLogPrefix        = "filebeat::logs::"
+	memlogFilestreamPrefix = "filestream::"
+)
+
+func (c *filebeatConfigurer) getRegistryFile() string {
+	return filepath.Join(c.filebeatHome, "data/registry")
+}
+
+// detectRegistryFormat detects format by the layout of registry. It returns
+// registryUnknown if filebeat has not written the registry.
+func detectRegistryFormat(path string) (registryFormat, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return registryUnknown, nil
+		}
+		return registryUnknown, err
+	}
+	if !fi.IsDir() {
+		return registryV6, nil
+	}
+
+	dir := filepath.Join(path, "filebeat")
+	data, err := ioutil.ReadFile(filepath.Join(dir, "meta.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return registryUnknown, err
+	}
+	if err == nil {
+		var meta struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return registryUnknown, fmt.Errorf("error decode registry meta: %v", err)
+		}
+		switch meta.Version {
+		case "0":
+			return registryV7, nil
+		case "1":
+			return registryMemlog, nil
+		}
+		return registryUnknown, fmt.Errorf("unknown registry version %q", meta.Version)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "log.json")); err == nil {
+		return registryMemlog, nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data.json")); err == nil {
+		return registryV7, nil
+	}
+	return registryUnknown, nil
+}
+
+// getRegsitryState reads registry of any format, states are keyed by source.
+// A source has several states if the file is rotated or recreated, the one
+// of the file on disk is picked, since it's what is being read. The others
+// belong to removed files and are equivalent to callers.
+func (c *filebeatConfigurer) getRegsitryState() (map[string]RegistryState, error) {
+	path := c.getRegistryFile()
+	format, err := detectRegistryFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []RegistryState
+	switch format {
+	case registryV6:
+		states, err = readRegistryArray(path)
+	case registryV7:
+		states, err = readRegistryArray(filepath.Join(path, "filebeat", "data.json"))
+	case registryMemlog:
+		states, err = readMemlog(filepath.Join(path, "filebeat"))
+	default:
+		return nil, fmt.Errorf("registry %s not found", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	statesMap := make(map[string]RegistryState, 0)
+	for _, state := range states {
+		if old, ok := statesMap[state.Source]; ok && onDisk(old) {
+			continue
+		}
+		statesMap[state.Source] = state
+	}
+	return statesMap, nil
+}
+
+// onDisk returns true if the state is of the file at its source.
+func onDisk(rs RegistryState) bool {
+	fi, err := os.Stat(rs.Source)
+	if err != nil {
+		return false
+	}
+	inode, device := fileInode(fi)
+	return inode == rs.FileStateOS.Inode && device == rs.FileStateOS.Device
+}
+
+func readRegistryArray(path string) ([]RegistryState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	states := make([]RegistryState, 0)
+	if err := json.NewDecoder(f).Decode(&states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// memlogState is the value of a memlog entry. Timestamp is encoded as
+// [sec, nsec] in memlog, it's not used so it's not decoded. States of
+// filestream inputs keep offset in cursor and source in meta.
+type memlogState struct {
+	Source      string    `json:"source"`
+	Offset      int64     `json:"offset"`
+	Type        string    `json:"type"`
+	FileStateOS FileInode `json:"FileStateOS"`
+	Cursor      struct {
+		Offset int64 `json:"offset"`
+	} `json:"cursor"`
+	Meta struct {
+		Source string `json:"source"`
+	} `json:"meta"`
+}
+
+// toRegistryState converts memlog entry to RegistryState, ok is false if the
+// entry is not a file state.
+func (s *memlogState) toRegistryState(key string) (state RegistryState, ok bool) {
+	switch {
+	case strings.HasPrefix(key, memlogLogPrefix):
+		return RegistryState{
+			Source:      s.Source,
+			Offset:      s.Offset,
+			Type:        s.Type,
+			FileStateOS: s.FileStateOS,
+		}, true
+	case strings.HasPrefix(key, memlogFilestreamPrefix):
+		// filestream::<input id>::native::<inode>-<device>
+		state = RegistryState{
+			Source: s.Meta.Source,
+			Offset: s.Cursor.Offset,
+			Type:   "filestream",
+		}
+		if i := strings.LastIndex(key, "::"); i >= 0 {
+			if ids := strings.SplitN(key[i+2:], "-", 2); len(ids) == 2 {
+				state.FileStateOS.Inode, _ = strconv.ParseUint(ids[0], 10, 64)
+				state.FileStateOS.Device, _ = strconv.ParseUint(ids[1], 10, 64)
+			}
+		}
+		return state, state.Source != ""
+	}
+	return RegistryState{}, false
+}
+
+// readMemlog loads the active checkpoint and applies updates in log.json.
+func readMemlog(dir string) ([]RegistryState, error) {
+	entries := make(map[string]memlogState)
+
+	active, err := ioutil.ReadFile(filepath.Join(dir, "active.dat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && len(strings.TrimSpace(string(active))) > 0 {
+		// active.dat contains absolute path in filebeat container, which
+		// may be mounted elsewhere here.
+		checkpoint := filepath.Join(dir, filepath.Base(strings.TrimSpace(string(active))))
+		data, err := ioutil.ReadFile(checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		var items []struct {
+			Key string `json:"_key"`
+			memlogState
+		}
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("error decode checkpoint %s: %v", checkpoint, err)
+		}
+		for _, item := range items {
+			entries[item.Key] = item.memlogState
+		}
+	}
+
+	if err := applyMemlogUpdates(filepath.Join(dir, "log.json"), entries); err != nil {
+		return nil, err
+	}
+
+	states := make([]RegistryState, 0, len(entries))
+	for key, entry := range entries {
+		if state, ok := entry.toRegistryState(key); ok {
+			states = append(states, state)
+		}
+	}
+	return states, nil
+}
+
+// applyMemlogUpdates applies operations in log.json to entries. Every
+// operation is a line of {"op":"set|remove","id":N} followed by a line of
+// {"k":key,"v":value}. The last operation may be partially written, it's
+// ignored.
+func applyMemlogUpdates(path string, entries map[string]memlogState) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var action struct {
+			Op string `json:"op"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			return nil
+		}
+		if !scanner.Scan() {
+			return nil
+		}
+		var update struct {
+			Key   string      `json:"k"`
+			Value memlogState `json:"v"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+			return nil
+		}
+		switch action.Op {
+		case "set":
+			entries[update.Key] = update.Value
+		case "remove":
+			delete(entries, update.Key)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,195 @@
+package filebeat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"gopkg.in/yaml.v2"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetRegistryState(t *testing.T) {
+	cases := []struct {
+		name   string
+		files  map[string]string
+		format registryFormat
+		expect map[string]int64
+	}{
+		{
+			name: "6.x",
+			files: map[string]string{
+				"data/registry": `[{"source":"/a.log","offset":10,"timestamp":"2019-01-02T00:00:00Z","ttl":-1,"type":"log","FileStateOS":{"inode":1,"device":2}}]`,
+			},
+			format: registryV6,
+			expect: map[string]int64{"/a.log": 10},
+		},
+		{
+			name: "7.0",
+			files: map[string]string{
+				"data/registry/filebeat/meta.json": `{"version":"0"}`,
+				"data/registry/filebeat/data.json": `[{"source":"/a.log","offset":20,"timestamp":"2019-01-02T00:00:00Z","ttl":-1,"type":"log","FileStateOS":{"inode":1,"device":2}}]`,
+			},
+			format: registryV7,
+			expect: map[string]int64{"/a.log": 20},
+		},
+		{
+			name: "memlog",
+			files: map[string]string{
+				"data/registry/filebeat/meta.json":  `{"version":"1"}`,
+				"data/registry/filebeat/active.dat": "/usr/share/filebeat/data/registry/filebeat/5.json",
+				"data/registry/filebeat/5.json": `[
+{"_key":"filebeat::logs::native::1-2","source":"/a.log","offset":30,"timestamp":[1,2],"ttl":-1,"type":"log","FileStateOS":{"inode":1,"device":2}},
+{"_key":"filebeat::logs::native::3-2","source":"/b.log","offset":5,"timestamp":[1,2],"ttl":-1,"type":"log","FileStateOS":{"inode":3,"device":2}}
+]`,
+				"data/registry/filebeat/log.json": `{"op":"set","id":6}
+{"k":"filebeat::logs::native::1-2","v":{"source":"/a.log","offset":40,"timestamp":[1,2],"ttl":-1,"type":"log","FileStateOS":{"inode":1,"device":2}}}
+{"op":"remove","id":7}
+{"k":"filebeat::logs::native::3-2"}
+{"op":"set","id":8}
+{"k":"filestream::1-app::native::4-2","v":{"cursor":{"offset":50},"meta":{"source":"/c.log","identifier_name":"native"},"ttl":-1}}
+{"op":"set","id":9}
+{"k":"filebeat::logs::native::5-2","v":{"source":"/d.lo`,
+			},
+			format: registryMemlog,
+			expect: map[string]int64{"/a.log": 40, "/c.log": 50},
+		},
+	}
+
+	for _, tc := range cases {
+		home, err := ioutil.TempDir("", "filebeat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(home)
+		writeFiles(t, home, tc.files)
+
+		c := &filebeatConfigurer{filebeatHome: home}
+		format, err := detectRegistryFormat(c.getRegistryFile())
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if format != tc.format {
+			t.Errorf("%s: expect format %s, got %s", tc.name, tc.format, format)
+		}
+		states, err := c.getRegsitryState()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(states) != len(tc.expect) {
+			t.Errorf("%s: expect %d states, got %v", tc.name, len(tc.expect), states)
+		}
+		for source, offset := range tc.expect {
+			if states[source].Offset != offset {
+				t.Errorf("%s: expect offset of %s %d, got %d", tc.name, source, offset, states[source].Offset)
+			}
+		}
+	}
+}
+
+func TestGetRegistryStateRotated(t *testing.T) {
+	home, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	source := filepath.Join(home, "a.log")
+	writeFiles(t, home, map[string]string{"a.log": "hello\n"})
+	fi, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inode, device := fileInode(fi)
+
+	// The state of the rotated file is written both before and after the other
+	for _, order := range [][2]int64{{10, 20}, {20, 10}} {
+		var lines []string
+		for i, offset := range order {
+			ino := inode
+			if offset == 10 {
+				ino = inode + 1
+			}
+			key := fmt.Sprintf("filebeat::logs::native::%d-%d", ino, device)
+			lines = append(lines, fmt.Sprintf(`{"op":"set","id":%d}`, i+1),
+				fmt.Sprintf(`{"k":%q,"v":{"source":%q,"offset":%d,"timestamp":[1,2],"ttl":-1,"type":"log","FileStateOS":{"inode":%d,"device":%d}}}`,
+					key, source, offset, ino, device))
+		}
+		writeFiles(t, home, map[string]string{
+			"data/registry/filebeat/meta.json": `{"version":"1"}`,
+			"data/registry/filebeat/log.json":  strings.Join(lines, "\n") + "\n",
+		})
+
+		c := &filebeatConfigurer{filebeatHome: home}
+		states, err := c.getRegsitryState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if states[source].Offset != 20 || states[source].FileStateOS.Inode != inode {
+			t.Errorf("expect state of the file on disk, got %+v", states[source])
+		}
+	}
+}
+
+func TestRenderFilestream(t *testing.T) {
+	tmpl, _, err := (&filebeatConfigurer{}).loadTemplate(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &filebeatConfigurer{tmpl: tmpl, inputType: inputTypeFilestream}
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{
+			{Name: "stdout", LogFile: "/var/lib/docker/containers/1/1-json.log", Stdout: true},
+			{Name: "app", LogFile: "/var/log/app.log", Format: configurer.LogFormatJSON},
+		},
+	}
+	content, err := c.render(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inputs []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &inputs); err != nil {
+		t.Fatalf("invalid yaml: %v\n%s", err, content)
+	}
+	if len(inputs) != 2 || inputs[0]["type"] != "filestream" || inputs[0]["id"] != "1-stdout" {
+		t.Errorf("unexpected inputs: %v", inputs)
+	}
+	if !strings.Contains(content, "format: docker") {
+		t.Errorf("expect container parser:\n%s", content)
+	}
+	if !strings.Contains(content, "- ndjson:") || strings.Count(content, "parsers:") != 2 {
+		t.Errorf("expect ndjson parser of json log:\n%s", content)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := Config{Version: "7.10.2", InputType: inputTypeFilestream}
+	if err := cfg.validate(); err == nil {
+		t.Error("expect error for filestream on 7.10")
+	}
+	cfg = Config{Version: "8.1.0", InputType: inputTypeFilestream}
+	if err := cfg.validate(); err != nil {
+		t.Error(err)
+	}
+	cfg = Config{}
+	if err := cfg.validate(); err != nil || cfg.InputType != inputTypeLog {
+		t.Errorf("expect log input by default, got %q, %v", cfg.InputType, err)
+	}
+}
@@ -0,0 +1,146 @@
+package filebeat
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"gopkg.in/fsnotify/fsnotify.v1"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+// templateReloadDelay coalesces events of a change, editors and ConfigMap
+// updates emit several events.
+const templateReloadDelay = time.Second
+
+// sampleEvent is rendered to validate input templates.
+var sampleEvent = &configurer.ContainerAddEvent{
+	Container: container.Container{
+		ID:        "0000000000000000000000000000000000000000000000000000000000000000",
+		Name:      "app",
+		Namespace: "default",
+		Pod:       "app-0",
+		PodID:     "00000000-0000-0000-0000-000000000000",
+	},
+	LogConfigs: []*configurer.LogConfig{
+		{
+			Name:    "stdout",
+			LogFile: "/var/lib/docker/containers/0000000000000000000000000000000000000000000000000000000000000000/*-json.log",
+			Format:  configurer.LogFormatPlain,
+			Tags:    map[string]string{"namespace": "default", "pod": "app-0"},
+			OutOpts: map[string]string{configurer.OutOptIndex: "app"},
+			Stdout:  true,
+		},
+		{
+			Name:    "access",
+			LogFile: "/var/lib/kubelet/pods/00000000-0000-0000-0000-000000000000/volumes/kubernetes.io~empty-dir/logs/access.log",
+			Format:  configurer.LogFormatJSON,
+			Tags:    map[string]string{"namespace": "default", "pod": "app-0"},
+		},
+	},
+}
+
+// loadTemplate parses the template file, and validates it by rendering
+// sampleEvent. It returns the template with checksum of the file.
+func (c *filebeatConfigurer) loadTemplate(path string) (*template.Template, [sha256.Size]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, [sha256.Size]byte{}, err
+	}
+	sum := sha256.Sum256(data)
+	t, err := parseTemplateData(path, data, c.version)
+	if err != nil {
+		return nil, sum, err
+	}
+	if _, err := c.renderWith(t, sampleEvent); err != nil {
+		return nil, sum, fmt.Errorf("error render sample: %v", err)
+	}
+	return t, sum, nil
+}
+
+// reloadTemplate re-renders inputs of running containers if the template
+// file is changed. The old template is kept if the new one fails to parse
+// or render any container, nothing is written in that case.
+func (c *filebeatConfigurer) reloadTemplate() error {
+	t, sum, err := c.loadTemplate(c.templatePath)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if sum == c.templateSum {
+		return nil
+	}
+
+	rendered := make(map[string]*renderedInput, len(c.containers))
+	for id, ev := range c.containers {
+		if g, ok := c.growth[id]; ok && g.degraded {
+			ev = degrade(ev, c.quarantine)
+		}
+		content, err := c.renderWith(t, ev)
+		if err != nil {
+			return fmt.Errorf("error render container %s: %v", id, err)
+		}
+		data, err := configurer.WithHeader(&ev.Container, currentInputConfigVersion, []byte(content))
+		if err != nil {
+			return fmt.Errorf("error encode header: %v", err)
+		}
+		rendered[id] = &renderedInput{container: ev.Container, data: data}
+	}
+
+	c.tmpl, c.templateSum = t, sum
+	for id, in := range rendered {
+		c.inputs[id] = in
+		c.dirty[c.inputFile(id)] = id
+	}
+	c.logger.Infof("Template %s reloaded, %d inputs rendered", c.templatePath, len(rendered))
+	return c.flush()
+}
+
+// watchTemplate reloads the template when it's changed. The directory is
+// watched since the file may be replaced, e.g. ConfigMap volumes swap the
+// "..data" symlink, see cmd/filebeat-keeper.
+func (c *filebeatConfigurer) watchTemplate() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(c.templatePath)); err != nil {
+		return err
+	}
+
+	var reloadCh <-chan time.Time
+	for {
+		select {
+		case <-c.closeCh:
+			return nil
+		case ev := <-w.Events:
+			if isTemplateEvent(c.templatePath, ev) {
+				c.logger.Debugf("Template event: %v", ev)
+				reloadCh = time.After(templateReloadDelay)
+			}
+		case err := <-w.Errors:
+			c.logger.Errorf("error watch template: %v", err)
+		case <-reloadCh:
+			reloadCh = nil
+			if err := c.reloadTemplate(); err != nil {
+				c.logger.Errorf("error reload template %s, the old one is kept: %v", c.templatePath, err)
+			}
+		}
+	}
+}
+
+func isTemplateEvent(path string, ev fsnotify.Event) bool {
+	if filepath.Base(ev.Name) == "..data" {
+		return ev.Op&fsnotify.Create == fsnotify.Create
+	}
+	return filepath.Clean(ev.Name) == filepath.Clean(path) &&
+		ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0
+}
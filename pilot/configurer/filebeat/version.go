@@ -1,9 +1,99 @@
 package filebeat
 
+import (
+	"fmt"
+	"os"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
 const (
 	inputConfigVersionV0_1 = "v0.1"
+	// inputConfigVersionV0_2 names files by hash of container ID, and
+	// identifies the container by header, see configurer.InputHeaderPrefix.
+	inputConfigVersionV0_2 = "v0.2"
 )
 
 var (
-	currentInputConfigVersion = inputConfigVersionV0_1
+	currentInputConfigVersion = inputConfigVersionV0_2
 )
+
+func init() {
+	registerMigration(inputConfigVersionV0_1, inputConfigVersionV0_2, retagInput)
+}
+
+// migration upgrades input config files from a version to the next one.
+// When currentInputConfigVersion is bumped, register a migration from the
+// previous version in init, e.g.
+//
+//	registerMigration(inputConfigVersionV0_2, inputConfigVersionV0_3, retagInput)
+type migration struct {
+	to string
+	// upgrade rewrites the file of info as version to, and updates info.
+	// ev is the last add event of the container, it's nil if unknown.
+	upgrade func(c *filebeatConfigurer, info *configurer.InputConfigFile, ev *configurer.ContainerAddEvent, to string) error
+}
+
+// migrations are keyed by the version they upgrade from.
+var migrations = make(map[string]migration)
+
+func registerMigration(from, to string,
+	upgrade func(c *filebeatConfigurer, info *configurer.InputConfigFile, ev *configurer.ContainerAddEvent, to string) error) {
+	if _, dup := migrations[from]; dup {
+		panic("filebeat: migration from " + from + " registered twice")
+	}
+	migrations[from] = migration{to: to, upgrade: upgrade}
+}
+
+// migrate upgrades the file to currentInputConfigVersion step by step,
+// inputs of running containers are rendered again by OnAdd. Versions without
+// a registered migration are refused, e.g. files written by a newer version
+// of log-pilot before a downgrade, since their content is unknown.
+func (c *filebeatConfigurer) migrate(info *configurer.InputConfigFile, ev *configurer.ContainerAddEvent) error {
+	for steps := 0; info.Version != currentInputConfigVersion; steps++ {
+		if steps > len(migrations) {
+			return fmt.Errorf("migration loop from version %s", info.Version)
+		}
+		m, ok := migrations[info.Version]
+		if !ok {
+			return fmt.Errorf("unknown version %s", info.Version)
+		}
+		from := info.Version
+		if err := m.upgrade(c, info, ev, m.to); err != nil {
+			return fmt.Errorf("error migrate from %s to %s: %v", from, m.to, err)
+		}
+		c.logger.Infof("Input config of container %s migrated from %s to %s", info.ContainerID, from, m.to)
+	}
+	return nil
+}
+
+// retagInput moves the file to the path of the container, and writes the
+// header of version to, the body is kept.
+func retagInput(c *filebeatConfigurer, info *configurer.InputConfigFile, ev *configurer.ContainerAddEvent, to string) error {
+	body, err := readInputBody(info.Path)
+	if err != nil {
+		return err
+	}
+	con := &container.Container{
+		ID:        info.ContainerID,
+		Name:      info.Container,
+		Namespace: info.Namespace,
+		Pod:       info.Pod,
+	}
+	data, err := configurer.WithHeader(con, to, body)
+	if err != nil {
+		return err
+	}
+	path := c.getInputPath(info.ContainerID)
+	if _, err := configurer.WriteFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	if path != info.Path {
+		if err := os.Remove(info.Path); err != nil {
+			return err
+		}
+	}
+	info.Path, info.Version = path, to
+	return nil
+}
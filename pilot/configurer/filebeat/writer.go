@@ -0,0 +1,253 @@
+package filebeat
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+// shardPrefix starts names of aggregated input files.
+const shardPrefix = "shard-"
+
+// renderedInput is the content written for a container, with header.
+type renderedInput struct {
+	container container.Container
+	data      []byte
+}
+
+func (c *filebeatConfigurer) getShardPath(containerID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(containerID))
+	return filepath.Join(c.getInputsDir(), fmt.Sprintf("%s%03d.yml", shardPrefix, h.Sum32()%uint32(c.shards)))
+}
+
+// inputFile returns path of the file contains input of the container.
+func (c *filebeatConfigurer) inputFile(containerID string) string {
+	if c.shards > 0 {
+		return c.getShardPath(containerID)
+	}
+	return c.getInputPath(containerID)
+}
+
+// hasInput reports whether input of the container is written or waiting
+// to be written.
+func (c *filebeatConfigurer) hasInput(con *container.Container) bool {
+	if _, ok := c.inputs[con.ID]; ok {
+		return true
+	}
+	if c.shards > 0 {
+		return false
+	}
+	_, err := os.Stat(c.inputFile(con.ID))
+	return err == nil
+}
+
+// setInput saves content of the container, it's written after writeDelay.
+func (c *filebeatConfigurer) setInput(con container.Container, data []byte) error {
+	c.inputs[con.ID] = &renderedInput{container: con, data: data}
+	c.dirty[c.inputFile(con.ID)] = con.ID
+	if c.writeDelay <= 0 {
+		return c.flush()
+	}
+	c.scheduleFlush()
+	return nil
+}
+
+// removeInput removes input of the container immediately.
+func (c *filebeatConfigurer) removeInput(containerID string) error {
+	delete(c.inputs, containerID)
+	c.dirty[c.inputFile(containerID)] = containerID
+	return c.flush()
+}
+
+// scheduleFlush flushes changes after writeDelay, changes made in the
+// window are written together. The caller should hold the lock.
+func (c *filebeatConfigurer) scheduleFlush() {
+	if c.flushTimer != nil {
+		return
+	}
+	c.flushTimer = time.AfterFunc(c.writeDelay, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.flushTimer = nil
+		if err := c.flush(); err != nil {
+			c.logger.Errorf("error write input configs: %v", err)
+			c.scheduleFlush()
+		}
+	})
+}
+
+// flush writes dirty files. Files failed to write stay dirty. The caller
+// should hold the lock.
+func (c *filebeatConfigurer) flush() error {
+	if len(c.dirty) == 0 {
+		return nil
+	}
+
+	var shards map[string][]*renderedInput
+	if c.shards > 0 {
+		shards = make(map[string][]*renderedInput)
+		for id, in := range c.inputs {
+			path := c.getShardPath(id)
+			if _, ok := c.dirty[path]; ok {
+				shards[path] = append(shards[path], in)
+			}
+		}
+	}
+
+	var errs []string
+	for path, id := range c.dirty {
+		var data []byte
+		if c.shards > 0 {
+			data = joinInputs(shards[path])
+		} else if in, ok := c.inputs[id]; ok {
+			data = in.data
+		}
+
+		if len(data) == 0 {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err.Error())
+				continue
+			}
+			c.logger.Infof("Input config %s removed", filepath.Base(path))
+		} else {
+			changed, err := configurer.WriteFileAtomic(path, data, 0644)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if changed {
+				c.logger.Infof("Input config %s updated", filepath.Base(path))
+			}
+		}
+		delete(c.dirty, path)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// joinInputs concatenates inputs sorted by container ID, so the content is
+// stable.
+func joinInputs(inputs []*renderedInput) []byte {
+	sort.Slice(inputs, func(i, j int) bool {
+		return inputs[i].container.ID < inputs[j].container.ID
+	})
+	var buf bytes.Buffer
+	for _, in := range inputs {
+		buf.Write(in.data)
+		if len(in.data) > 0 && in.data[len(in.data)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// splitInputs splits an aggregated file into inputs by headers.
+func splitInputs(data []byte) ([]*renderedInput, error) {
+	var ret []*renderedInput
+	for len(data) > 0 {
+		if !bytes.HasPrefix(data, []byte(configurer.InputHeaderPrefix)) {
+			return nil, fmt.Errorf("header expected")
+		}
+		end := bytes.Index(data, []byte("\n"+configurer.InputHeaderPrefix))
+		if end < 0 {
+			end = len(data)
+		} else {
+			end++
+		}
+		section := data[:end]
+		data = data[end:]
+
+		line := section
+		if i := bytes.IndexByte(section, '\n'); i >= 0 {
+			line = section[:i]
+		}
+		header, err := configurer.ParseInputHeader(string(line))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, &renderedInput{
+			container: container.Container{
+				ID:        header.ContainerID,
+				Name:      header.Container,
+				Namespace: header.Namespace,
+				Pod:       header.Pod,
+				PodID:     header.PodID,
+			},
+			data: section,
+		})
+	}
+	return ret, nil
+}
+
+// loadShards reads aggregated files. In shard mode, inputs are kept in
+// memory, and containers are watched by GC until OnAdd claims them. In file
+// per container mode, inputs are written to their own files, and the
+// aggregated files are removed.
+func (c *filebeatConfigurer) loadShards() error {
+	dir := c.getInputsDir()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		base := fi.Name()
+		if fi.IsDir() || !isShardFile(base) {
+			continue
+		}
+		path := filepath.Join(dir, base)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		inputs, err := splitInputs(data)
+		if err != nil {
+			return fmt.Errorf("error load %s: %v", base, err)
+		}
+		if c.shards == 0 {
+			for _, in := range inputs {
+				if _, err := configurer.WriteFileAtomic(c.getInputPath(in.container.ID), in.data, 0644); err != nil {
+					return err
+				}
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, in := range inputs {
+			c.claimLater(in)
+		}
+		// shard count may change, files are written by the new count
+		c.dirty[path] = ""
+	}
+	return nil
+}
+
+// claimLater keeps the input in shard mode, the container is watched by GC
+// until OnAdd claims it.
+func (c *filebeatConfigurer) claimLater(in *renderedInput) {
+	id := in.container.ID
+	c.inputs[id] = in
+	c.dirty[c.getShardPath(id)] = id
+	if _, ok := c.watchContainer[id]; !ok {
+		con := in.container
+		c.watchContainer[id] = &logStates{Container: &con, destroyed: time.Now()}
+	}
+}
+
+func isShardFile(base string) bool {
+	return strings.HasPrefix(base, shardPrefix) && strings.HasSuffix(base, ".yml")
+}
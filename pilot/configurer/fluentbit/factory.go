@@ -0,0 +1,37 @@
+package fluentbit
+
+import (
+	"flag"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+// Config is the config section of fluentbit configurer.
+type Config struct {
+	// Template defaults to configurer.Options.Template.
+	Template string `yaml:"template"`
+	Home     string `yaml:"home"`
+	// DB is the tail DB path, it's read by sqlite3 command, so sqlite3 must
+	// be installed.
+	DB string `yaml:"db"`
+}
+
+func init() {
+	configurer.Register("fluentbit", configurer.Factory{
+		Config: func() interface{} { return &Config{} },
+		Flags: func(fs *flag.FlagSet, cfg interface{}) {
+			c := cfg.(*Config)
+			fs.StringVar(&c.Template, "path.fluentbit-template", "", "Template file path for fluentbit, defaults to path.template")
+			fs.StringVar(&c.Home, "path.fluentbit-home", "", "Fluent Bit home path")
+			fs.StringVar(&c.DB, "path.fluentbit-db", "", "Fluent Bit tail DB path, it's read by sqlite3 command which must be installed")
+		},
+		New: func(opts *configurer.Options, cfg interface{}) (configurer.Configurer, error) {
+			c := cfg.(*Config)
+			tmpl := c.Template
+			if tmpl == "" {
+				tmpl = opts.Template
+			}
+			return New(opts.BaseDir, tmpl, c.Home, c.DB)
+		},
+	})
+}
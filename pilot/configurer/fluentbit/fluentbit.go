@@ -0,0 +1,367 @@
+package fluentbit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+// TailState is a row of in_tail_files table in fluent bit tail DB.
+type TailState struct {
+	Name   string
+	Offset int64
+	Inode  uint64
+}
+
+// logStates contains states in tail DB and related to the container
+type logStates struct {
+	*container.Container
+	states []TailState
+}
+
+type fluentbitConfigurer struct {
+	name string
+	base string
+	// Fluent Bit home path, input configs are written to <home>/inputs.d,
+	// which should be included by fluent-bit.conf with:
+	//   @INCLUDE inputs.d/*.conf
+	fluentbitHome string
+	// dbPath is the tail DB, it should be the same as DB option in template.
+	dbPath         string
+	tmpl           *template.Template
+	closeCh        chan bool
+	watchDuration  time.Duration
+	watchContainer map[string]*logStates
+	logger         log.Logger
+	lock           sync.Mutex
+}
+
+// New creates a new fluent bit configurer. GC reads tail DB by sqlite3
+// command, so it must be installed, see build/log-pilot/Dockerfile.
+func New(baseDir, configTemplateFile, fluentbitHome, dbPath string) (configurer.Configurer, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("sqlite3 is required to read tail DB: %v", err)
+	}
+
+	t, err := parseTemplate(configTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parse log template: %v", err)
+	}
+
+	if _, err := os.Stat(fluentbitHome); err != nil {
+		return nil, err
+	}
+
+	logger := log.NewLogger("configurer")
+	c := &fluentbitConfigurer{
+		logger:         logger,
+		name:           "fluentbit",
+		fluentbitHome:  fluentbitHome,
+		dbPath:         dbPath,
+		base:           baseDir,
+		tmpl:           t,
+		closeCh:        make(chan bool),
+		watchContainer: make(map[string]*logStates),
+		watchDuration:  60 * time.Second,
+	}
+
+	if err := os.MkdirAll(c.getInputsDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *fluentbitConfigurer) Name() string {
+	return c.name
+}
+
+func (c *fluentbitConfigurer) Start() error {
+	go func() {
+		if err := c.watch(); err != nil {
+			c.logger.Errorf("error watch: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (c *fluentbitConfigurer) Stop() {
+	close(c.closeCh)
+}
+
+func (c *fluentbitConfigurer) getInputsDir() string {
+	return filepath.Join(c.fluentbitHome, "inputs.d")
+}
+
+// BootstrapCheck removes unknown files and old version files, and returns
+// all the input files.
+func (c *fluentbitConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	inputConfDir := c.getInputsDir()
+	if _, err := configurer.RemoveTempFiles(inputConfDir); err != nil {
+		return nil, fmt.Errorf("error remove temporary files: %v", err)
+	}
+	files, err := ioutil.ReadDir(inputConfDir)
+	if err != nil {
+		return nil, err
+	}
+
+	toRemove := []string{}
+	ret := make(map[string]*configurer.InputConfigFile)
+	for i := range files {
+		base := files[i].Name()
+		inputConfig, err := configurer.LoadInput(filepath.Join(inputConfDir, base))
+		if err != nil {
+			log.Warnf("unable to load input config %s: %v", base, err)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		if inputConfig.Version != currentInputConfigVersion {
+			log.Infof("old version: %s", base)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		ret[inputConfig.ContainerID] = inputConfig
+	}
+
+	for _, base := range toRemove {
+		if err := os.Remove(filepath.Join(inputConfDir, base)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// <hash of container ID>.conf, see configurer.InputFileName.
+func (c *fluentbitConfigurer) getContainerConfigPath(con *container.Container) string {
+	return filepath.Join(c.getInputsDir(), configurer.InputFileName(con.ID, ".conf"))
+}
+
+func (c *fluentbitConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	if err := configurer.RejectRedactions(c.Name(), ev); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, err := c.render(ev)
+	if err != nil {
+		return fmt.Errorf("error render config file: %v", err)
+	}
+
+	confPath := c.getContainerConfigPath(&ev.Container)
+	data, err := configurer.WithHeader(&ev.Container, currentInputConfigVersion, []byte(content))
+	if err != nil {
+		return err
+	}
+	if _, err := configurer.WriteFileAtomic(confPath, data, 0644); err != nil {
+		return fmt.Errorf("error write config file: %v", err)
+	}
+
+	c.logger.Info("Configuration updated successfully for container", ev.Container.ID)
+	return nil
+}
+
+// parseTemplate parses input template file, quote escapes values of
+// properties, e.g. `Record {{ quote $key }} {{ quote $value }}`.
+func parseTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(template.FuncMap{"quote": quote}).ParseFiles(path)
+}
+
+var quoteReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// quote returns s in double quotes with backslash escapes, so spaces and
+// newlines don't break the config.
+func quote(s string) string {
+	return `"` + quoteReplacer.Replace(s) + `"`
+}
+
+// render generates input config, the tag of each log source is
+// kube.<namespace>.<pod>.<container>.<name>, it can be matched by filters.
+func (c *fluentbitConfigurer) render(ev *configurer.ContainerAddEvent) (string, error) {
+	var buf bytes.Buffer
+	con := ev.Container
+	context := map[string]interface{}{
+		"containerId": con.ID,
+		"tagPrefix":   strings.Join([]string{"kube", con.Namespace, con.Pod, con.Name}, "."),
+		"db":          c.dbPath,
+		"configList":  ev.LogConfigs,
+	}
+	if err := c.tmpl.Execute(&buf, context); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *fluentbitConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.watchContainer[ev.Container.ID]; !ok {
+		c.watchContainer[ev.Container.ID] = &logStates{
+			Container: &ev.Container,
+		}
+	}
+	return nil
+}
+
+func (c *fluentbitConfigurer) watch() error {
+	c.logger.Infof("%s watcher start", c.Name())
+	for {
+		select {
+		case <-c.closeCh:
+			c.logger.Infof("%s watcher stop", c.Name())
+			return nil
+		case <-time.After(c.watchDuration):
+			c.logger.Infof("%s watcher scan", c.Name())
+
+			startTs := time.Now()
+			err := c.scan()
+			c.logger.Debugf("cost %v to complete scan", time.Since(startTs))
+			if err != nil {
+				c.logger.Errorf("%s watcher scan error: %v", c.Name(), err)
+			}
+		}
+	}
+}
+
+// scan gc for input files
+func (c *fluentbitConfigurer) scan() error {
+	states, err := c.getTailStates()
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for container, lst := range c.watchContainer {
+		confPath := c.getContainerConfigPath(lst.Container)
+		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
+			c.logger.Infof("log config %s.conf has been removed and ignore", container)
+			delete(c.watchContainer, container)
+		} else if c.canRemoveConf(container, states, lst) {
+			c.logger.Infof("try to remove log config %s.conf", container)
+			if err := os.Remove(confPath); err != nil {
+				c.logger.Errorf("remove log config %s.conf fail: %v", container, err)
+			} else {
+				delete(c.watchContainer, container)
+			}
+		} else {
+			c.logger.Debugf("%s.conf cannot be removed for now, will try to remove it in next scan", container)
+		}
+	}
+	return nil
+}
+
+// logDirPrefixes returns directories which contain log files of the container.
+func logDirPrefixes(base string, con *container.Container) []string {
+	return []string{
+		filepath.Join(base, fmt.Sprintf("/var/lib/kubelet/pods/%s/volumes/kubernetes.io~empty-dir", con.PodID)),
+		filepath.Join(base, fmt.Sprintf("/var/lib/docker/containers/%s", con.ID)),
+	}
+}
+
+// canRemoveConf checks whether input file of a destroyed container can be
+// removed. It finds states of the container in tail DB, and compares their
+// offsets with sizes of the files.
+func (c *fluentbitConfigurer) canRemoveConf(container string, db map[string]TailState, lst *logStates) bool {
+	prefixes := logDirPrefixes(c.base, lst.Container)
+
+	var states []TailState
+	for name, ts := range db {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				states = append(states, ts)
+				break
+			}
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	lst.states = states
+
+	var lag int64
+	for _, ts := range states {
+		lag += tailLag(ts)
+	}
+	if lag > 0 {
+		c.logger.Debugf("inputs for container %s cannot be removed for now, %d bytes are not read", container, lag)
+		return false
+	}
+	return true
+}
+
+// tailLag returns how many bytes of the file are not read. The file is
+// considered read if it's removed or replaced, since fluent bit can't read
+// it any more.
+func tailLag(ts TailState) int64 {
+	fi, err := os.Stat(ts.Name)
+	if err != nil {
+		return 0
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && uint64(st.Ino) != ts.Inode {
+		return 0
+	}
+	if ts.Offset < fi.Size() {
+		return fi.Size() - ts.Offset
+	}
+	return 0
+}
+
+// getTailStates reads in_tail_files table of tail DB.
+func (c *fluentbitConfigurer) getTailStates() (map[string]TailState, error) {
+	if _, err := os.Stat(c.dbPath); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("sqlite3", "-separator", "\t", c.dbPath,
+		"SELECT name, offset, inode FROM in_tail_files;").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error query tail db: %v", err)
+	}
+	return parseTailStates(out)
+}
+
+// parseTailStates parses sqlite3 output, each line is: <name>\t<offset>\t<inode>
+func parseTailStates(out []byte) (map[string]TailState, error) {
+	ret := make(map[string]TailState)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		items := strings.Split(line, "\t")
+		if len(items) != 3 {
+			return nil, fmt.Errorf("invalid tail state: %q", line)
+		}
+		offset, err := strconv.ParseInt(items[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset of %s: %v", items[0], err)
+		}
+		inode, err := strconv.ParseUint(items[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid inode of %s: %v", items[0], err)
+		}
+		if _, ok := ret[items[0]]; !ok {
+			ret[items[0]] = TailState{Name: items[0], Offset: offset, Inode: inode}
+		}
+	}
+	return ret, scanner.Err()
+}
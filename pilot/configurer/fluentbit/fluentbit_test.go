@@ -0,0 +1,111 @@
+package fluentbit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+func TestRender(t *testing.T) {
+	tmpl, err := parseTemplate("fluentbit.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &fluentbitConfigurer{
+		tmpl:   tmpl,
+		dbPath: "/fluent-bit/data/tail.db",
+	}
+	ev := configurer.ContainerAddEvent{
+		Container: container.Container{
+			ID:        "1",
+			Namespace: "default",
+			Pod:       "tomcat",
+			Name:      "tomcat",
+		},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{
+				Name:    "access",
+				LogFile: "/opt/tomcat/access.log",
+				Format:  configurer.LogFormatNginx,
+				Tags:    map[string]string{"foo": "bar", "desc": "a \"b\"\nc"},
+			},
+		},
+	}
+	content, err := c.render(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		"Tag               kube.default.tomcat.tomcat.access",
+		"Parser            nginx",
+		`Record            "foo" "bar"`,
+		`Record            "desc" "a \"b\"\nc"`,
+	} {
+		if !strings.Contains(content, expect) {
+			t.Errorf("expect %q in rendered config:\n%s", expect, content)
+		}
+	}
+}
+
+func TestParseTailStates(t *testing.T) {
+	states, err := parseTailStates([]byte("/var/log/a.log\t100\t1\n/var/log/b.log\t0\t2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states["/var/log/a.log"].Offset != 100 {
+		t.Errorf("unexpected states: %v", states)
+	}
+	if _, err := parseTailStates([]byte("/var/log/a.log\tx\t1\n")); err == nil {
+		t.Error("expect error for invalid offset")
+	}
+}
+
+func TestCanRemoveConf(t *testing.T) {
+	base, err := ioutil.TempDir("", "fluentbit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	con := &container.Container{ID: "1", PodID: "uid"}
+	dir := filepath.Join(logDirPrefixes(base, con)[0], "logs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inode := uint64(fi.Sys().(*syscall.Stat_t).Ino)
+
+	c := &fluentbitConfigurer{base: base, logger: logp.NewLogger("test")}
+	lst := &logStates{Container: con}
+	db := map[string]TailState{path: {Name: path, Offset: 2, Inode: inode}}
+	// Unchanged states don't mean the file is read
+	for i := 0; i < 2; i++ {
+		if c.canRemoveConf("1", db, lst) {
+			t.Fatal("expect input kept before the file is read")
+		}
+	}
+	db[path] = TailState{Name: path, Offset: 6, Inode: inode}
+	if !c.canRemoveConf("1", db, lst) {
+		t.Error("expect input removed after the file is read")
+	}
+	db[path] = TailState{Name: path, Offset: 2, Inode: inode + 1}
+	if !c.canRemoveConf("1", db, lst) {
+		t.Error("expect input removed if the file is replaced")
+	}
+}
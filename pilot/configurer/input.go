@@ -0,0 +1,104 @@
+package configurer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+// InputHeaderPrefix starts the first line of input config files, the line is
+// a comment contains identity of the container in json, so names with "_"
+// and containers without kubernetes labels are safe.
+const InputHeaderPrefix = "# log-pilot: "
+
+// ErrNoInputHeader is returned by LoadInput if the file has no header.
+var ErrNoInputHeader = errors.New("no input header")
+
+// InputHeader is the identity of the container in the header.
+type InputHeader struct {
+	Namespace   string `json:"namespace"`
+	Pod         string `json:"pod"`
+	PodID       string `json:"podId,omitempty"`
+	Container   string `json:"container"`
+	ContainerID string `json:"containerId"`
+	Version     string `json:"version"`
+}
+
+// InputFileName returns name of the input config file of the container,
+// which is <hash of container ID><ext>.
+func InputFileName(containerID, ext string) string {
+	sum := sha256.Sum256([]byte(containerID))
+	return hex.EncodeToString(sum[:16]) + ext
+}
+
+// WithHeader prepends the identity header of version to body.
+func WithHeader(con *container.Container, version string, body []byte) ([]byte, error) {
+	header, err := json.Marshal(&InputHeader{
+		Namespace:   con.Namespace,
+		Pod:         con.Pod,
+		PodID:       con.PodID,
+		Container:   con.Name,
+		ContainerID: con.ID,
+		Version:     version,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(InputHeaderPrefix)
+	buf.Write(header)
+	buf.WriteByte('\n')
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// ParseInputHeader parses the header line of input config files.
+func ParseInputHeader(line string) (*InputHeader, error) {
+	header := &InputHeader{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, InputHeaderPrefix)), header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	if header.ContainerID == "" || header.Version == "" {
+		return nil, fmt.Errorf("container ID and version are required in header")
+	}
+	return header, nil
+}
+
+// LoadInput reads identity of the input config file from its header, it
+// returns ErrNoInputHeader if the file doesn't start with a header.
+func LoadInput(path string) (*InputConfigFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if !strings.HasPrefix(line, InputHeaderPrefix) {
+		return nil, ErrNoInputHeader
+	}
+	if err != nil {
+		return nil, fmt.Errorf("incomplete header: %v", err)
+	}
+
+	header, err := ParseInputHeader(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	return &InputConfigFile{
+		Namespace:   header.Namespace,
+		Pod:         header.Pod,
+		PodID:       header.PodID,
+		Container:   header.Container,
+		ContainerID: header.ContainerID,
+		Version:     header.Version,
+		Path:        path,
+	}, nil
+}
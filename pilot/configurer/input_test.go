@@ -0,0 +1,48 @@
+package configurer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/container"
+)
+
+func TestLoadInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// docker container without kubernetes labels, and name with "_"
+	con := &container.Container{ID: "abc", Name: "my_app", PodID: "uid"}
+	base := InputFileName(con.ID, ".yaml")
+	if base != InputFileName("abc", ".yaml") || filepath.Ext(base) != ".yaml" || len(base) != 32+5 {
+		t.Errorf("unexpected name %s", base)
+	}
+	data, err := WithHeader(con, "v0.1", []byte("sources: {}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, base)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := LoadInput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Container != con.Name || info.ContainerID != con.ID || info.PodID != con.PodID ||
+		info.Version != "v0.1" || info.Path != path {
+		t.Errorf("unexpected input %+v", info)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("sources: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadInput(path); err != ErrNoInputHeader {
+		t.Errorf("expect ErrNoInputHeader, got %v", err)
+	}
+}
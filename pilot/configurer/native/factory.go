@@ -0,0 +1,34 @@
+package native
+
+import (
+	"flag"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+func init() {
+	configurer.Register("native", configurer.Factory{
+		Config: func() interface{} {
+			return &Config{
+				Output: OutputConfig{
+					Type:    "elasticsearch",
+					Index:   "logstash",
+					Timeout: 30 * time.Second,
+				},
+			}
+		},
+		Flags: func(fs *flag.FlagSet, cfg interface{}) {
+			c := cfg.(*Config)
+			fs.StringVar(&c.DataDir, "path.native-data", "", "Data directory of the native shipper")
+			fs.StringVar(&c.Output.Type, "native.output", c.Output.Type, "Output of the native shipper: elasticsearch, kafka, kafka-rest (through a Confluent compatible REST proxy)")
+			fs.Var((*configurer.StringList)(&c.Output.Hosts), "native.hosts", "Elasticsearch URLs, kafka brokers or kafka REST proxy URLs of the native shipper")
+			fs.StringVar(&c.Output.Index, "native.index", c.Output.Index, "Elasticsearch index prefix of the native shipper")
+			fs.StringVar(&c.Output.Topic, "native.topic", "", "Default kafka topic of the native shipper")
+			fs.DurationVar(&c.GCMaxWait, "native.gc-max-wait", 6*time.Hour, "Max time to wait for logs of a destroyed container to be shipped, 0 means forever")
+		},
+		New: func(opts *configurer.Options, cfg interface{}) (configurer.Configurer, error) {
+			return New(opts.BaseDir, *cfg.(*Config))
+		},
+	})
+}
@@ -0,0 +1,335 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+const (
+	// maxLineBytes truncates long lines, the rest of the line is skipped.
+	maxLineBytes = 1 << 20
+	// checkInterval is how long harvester waits at the end of file before
+	// it checks rotation and truncation.
+	checkInterval = time.Second
+	// defaultPartialTimeout is how long an incomplete last line stays
+	// unchanged before the file is considered finished, the line is never
+	// shipped.
+	defaultPartialTimeout = time.Minute
+)
+
+// harvester reads a file line by line and sends events to the queue. It
+// follows the path, when the file is rotated, the old file is read to the
+// end before the new file is opened.
+type harvester struct {
+	path       string
+	cfg        *configurer.LogConfig
+	redactions []redaction
+	registry   *registry
+	out        chan<- *Event
+	done       chan struct{}
+	logger     log.Logger
+
+	file   *os.File
+	reader *bufio.Reader
+	// line is the incomplete line read so far, lineLen may be larger than
+	// len(line) if the line is truncated.
+	line    []byte
+	lineLen int64
+
+	lock   sync.Mutex
+	dev    uint64
+	inode  uint64
+	offset int64
+	eof    bool
+	// partialLen is length of the incomplete line at EOF, partialSince is
+	// when it's changed.
+	partialLen     int64
+	partialSince   time.Time
+	partialTimeout time.Duration
+}
+
+type redaction struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+func compileRedactions(rules []configurer.RedactionRule) ([]redaction, error) {
+	var ret []redaction
+	for _, rule := range rules {
+		pattern := rule.Pattern
+		if rule.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, redaction{re: re, replacement: rule.Replacement})
+	}
+	return ret, nil
+}
+
+func newHarvester(path string, cfg *configurer.LogConfig, redactions []redaction, reg *registry,
+	out chan<- *Event, logger log.Logger) (*harvester, error) {
+	h := &harvester{
+		path:       path,
+		cfg:        cfg,
+		redactions: redactions,
+		registry:   reg,
+		out:        out,
+		done:       make(chan struct{}),
+		logger:     logger,
+
+		partialTimeout: defaultPartialTimeout,
+	}
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// open opens the path and seeks to the acknowledged offset.
+func (h *harvester) open() error {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	dev, inode := fileID(fi)
+	offset := h.registry.offset(dev, inode)
+	if offset > fi.Size() {
+		// truncated while it's not harvested
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	if h.file != nil {
+		h.file.Close()
+	}
+	h.file = f
+	h.reader = bufio.NewReader(f)
+	h.line, h.lineLen = nil, 0
+
+	h.lock.Lock()
+	h.dev, h.inode, h.offset, h.eof = dev, inode, offset, false
+	h.partialLen, h.partialSince = 0, time.Time{}
+	h.lock.Unlock()
+	return nil
+}
+
+func (h *harvester) stop() {
+	close(h.done)
+}
+
+// finished reports whether all lines of the file are shipped. An incomplete
+// last line unchanged for partialTimeout is ignored.
+func (h *harvester) finished() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	stale := h.partialLen > 0 && time.Since(h.partialSince) >= h.partialTimeout
+	return (h.eof || stale) && h.registry.offset(h.dev, h.inode) >= h.offset
+}
+
+func (h *harvester) key() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return fileKey(h.dev, h.inode)
+}
+
+func (h *harvester) run() {
+	// the file is reopened if it's rotated
+	defer func() { h.file.Close() }()
+	for {
+		data, err := h.reader.ReadSlice('\n')
+		if len(data) > 0 {
+			h.lineLen += int64(len(data))
+			if room := maxLineBytes - len(h.line); room > 0 {
+				if len(data) > room {
+					data = data[:room]
+				}
+				h.line = append(h.line, data...)
+			}
+		}
+
+		switch err {
+		case nil:
+			h.lock.Lock()
+			h.offset += h.lineLen
+			offset, dev, inode := h.offset, h.dev, h.inode
+			h.eof = false
+			h.lock.Unlock()
+
+			ev := h.event(bytes.TrimRight(h.line, "\r\n"), offset, dev, inode)
+			h.line, h.lineLen = nil, 0
+			select {
+			case h.out <- ev:
+			case <-h.done:
+				return
+			}
+		case bufio.ErrBufferFull:
+			// the line is longer than the buffer, keep reading
+		case io.EOF:
+			h.lock.Lock()
+			h.eof = h.lineLen == 0
+			if h.lineLen != h.partialLen {
+				h.partialLen, h.partialSince = h.lineLen, time.Now()
+			}
+			h.lock.Unlock()
+			select {
+			case <-h.done:
+				return
+			case <-time.After(checkInterval):
+			}
+			if err := h.check(); err != nil {
+				h.logger.Warnf("error check %s: %v", h.path, err)
+			}
+		default:
+			h.logger.Errorf("error read %s: %v", h.path, err)
+			select {
+			case <-h.done:
+				return
+			case <-time.After(checkInterval):
+			}
+		}
+	}
+}
+
+// check reopens the path if the file is rotated, and reads from the beginning
+// if the file is truncated.
+func (h *harvester) check() error {
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// removed, nothing more will be written
+			return nil
+		}
+		return err
+	}
+	dev, inode := fileID(fi)
+
+	h.lock.Lock()
+	rotated := dev != h.dev || inode != h.inode
+	truncated := fi.Size() < h.offset+h.lineLen
+	h.lock.Unlock()
+
+	if rotated {
+		h.logger.Infof("%s is rotated", h.path)
+		return h.open()
+	}
+	if truncated {
+		h.logger.Infof("%s is truncated", h.path)
+		if _, err := h.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		h.reader.Reset(h.file)
+		h.line, h.lineLen = nil, 0
+		h.lock.Lock()
+		h.offset, h.eof = 0, false
+		h.partialLen, h.partialSince = 0, time.Time{}
+		h.lock.Unlock()
+	}
+	return nil
+}
+
+// event builds the event from a line. Docker json envelope of stdout and
+// json payload are decoded, other formats are left to the ingest side.
+func (h *harvester) event(line []byte, offset int64, dev, inode uint64) *Event {
+	ev := &Event{
+		Timestamp: time.Now(),
+		Fields:    make(map[string]interface{}, len(h.cfg.Tags)+3),
+		OutOpts:   h.cfg.OutOpts,
+		Source:    h.path,
+		Device:    dev,
+		Inode:     inode,
+		Offset:    offset,
+	}
+	for k, v := range h.cfg.Tags {
+		ev.Fields[k] = v
+	}
+	ev.Fields["source"] = h.path
+
+	message := string(line)
+	if h.cfg.Stdout {
+		var envelope struct {
+			Log    string    `json:"log"`
+			Stream string    `json:"stream"`
+			Time   time.Time `json:"time"`
+		}
+		if err := json.Unmarshal(line, &envelope); err == nil {
+			message = envelope.Log
+			if len(message) > 0 && message[len(message)-1] == '\n' {
+				message = message[:len(message)-1]
+			}
+			ev.Fields["stream"] = envelope.Stream
+			if !envelope.Time.IsZero() {
+				ev.Timestamp = envelope.Time
+			}
+		}
+	}
+	ev.Fields["message"] = message
+
+	if h.cfg.JSONDecode != nil || h.cfg.Format == configurer.LogFormatJSON {
+		decodeJSON(ev.Fields, message, h.cfg.JSONDecode)
+	}
+	// Redaction goes after decoding, content escaped in json is only visible
+	// in decoded fields.
+	if len(h.redactions) > 0 {
+		redactFields(ev.Fields, h.redactions)
+	}
+	return ev
+}
+
+// redactFields masks string values of fields recursively.
+func redactFields(fields map[string]interface{}, redactions []redaction) {
+	for k, v := range fields {
+		switch v := v.(type) {
+		case string:
+			for _, r := range redactions {
+				v = r.re.ReplaceAllString(v, r.replacement)
+			}
+			fields[k] = v
+		case map[string]interface{}:
+			redactFields(v, redactions)
+		}
+	}
+}
+
+func decodeJSON(fields map[string]interface{}, message string, opts *configurer.JSONDecodeOptions) {
+	if opts == nil {
+		opts = &configurer.JSONDecodeOptions{}
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &decoded); err != nil {
+		if opts.AddErrorKey {
+			fields["error"] = map[string]interface{}{"message": err.Error()}
+		}
+		return
+	}
+	if opts.Target != "" {
+		if _, ok := fields[opts.Target]; !ok || opts.OverwriteKeys {
+			fields[opts.Target] = decoded
+		}
+		return
+	}
+	for k, v := range decoded {
+		if _, ok := fields[k]; !ok || opts.OverwriteKeys {
+			fields[k] = v
+		}
+	}
+}
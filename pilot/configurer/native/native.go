@@ -0,0 +1,440 @@
+package native
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/log"
+)
+
+const maxBackoff = 30 * time.Second
+
+// Config configures the native shipper.
+type Config struct {
+	// DataDir stores the registry of shipped offsets, and log configs of
+	// containers in inputs, so logs of containers destroyed while log-pilot
+	// is down are still shipped.
+	DataDir string       `yaml:"dataDir"`
+	Output  OutputConfig `yaml:"output"`
+	// BatchSize is the max number of events in a request.
+	BatchSize int `yaml:"batchSize"`
+	// QueueSize bounds events read but not shipped, harvesters are blocked
+	// when the queue is full.
+	QueueSize int `yaml:"queueSize"`
+	// FlushInterval is how long a partial batch waits before it's shipped.
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// GCMaxWait is how long to wait for logs of a destroyed container to be
+	// shipped before they are given up, 0 means forever.
+	GCMaxWait time.Duration `yaml:"gcMaxWait"`
+}
+
+// nativeConfigurer tails log files and ships them by itself, no external
+// agent is needed. Events are shipped at least once: offsets are
+// acknowledged after output accepts them, and reading restarts from the
+// acknowledged offsets.
+type nativeConfigurer struct {
+	name          string
+	base          string
+	inputDir      string
+	output        Output
+	registry      *registry
+	queue         chan *Event
+	batchSize     int
+	flushInterval time.Duration
+	gcMaxWait     time.Duration
+	closeCh       chan bool
+	watchDuration time.Duration
+	// containers are tracked containers, keyed by container ID. Destroyed
+	// containers are kept until their logs are shipped.
+	containers map[string]*containerState
+	logger     log.Logger
+	lock       sync.Mutex
+}
+
+type containerState struct {
+	*configurer.ContainerAddEvent
+	redactions [][]redaction
+	// harvesters are keyed by file path.
+	harvesters map[string]*harvester
+	// destroyed is when the container is destroyed, zero if it's running.
+	destroyed time.Time
+}
+
+// New creates a native shipper configurer.
+func New(baseDir string, cfg Config) (configurer.Configurer, error) {
+	output, err := NewOutput(cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("error create output: %v", err)
+	}
+	return newConfigurer(baseDir, cfg, output)
+}
+
+func newConfigurer(baseDir string, cfg Config, output Output) (*nativeConfigurer, error) {
+	if cfg.DataDir == "" {
+		return nil, fmt.Errorf("data dir is required")
+	}
+	inputDir := filepath.Join(cfg.DataDir, "inputs")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		return nil, err
+	}
+	reg, err := loadRegistry(filepath.Join(cfg.DataDir, "registry.json"))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 4 * cfg.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+
+	return &nativeConfigurer{
+		logger:        log.NewLogger("configurer"),
+		name:          "native",
+		base:          baseDir,
+		inputDir:      inputDir,
+		output:        output,
+		registry:      reg,
+		queue:         make(chan *Event, cfg.QueueSize),
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+		gcMaxWait:     cfg.GCMaxWait,
+		closeCh:       make(chan bool),
+		watchDuration: 10 * time.Second,
+		containers:    make(map[string]*containerState),
+	}, nil
+}
+
+func (c *nativeConfigurer) Name() string {
+	return c.name
+}
+
+func (c *nativeConfigurer) Start() error {
+	go c.publish()
+	go c.watch()
+	return nil
+}
+
+func (c *nativeConfigurer) Stop() {
+	close(c.closeCh)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, s := range c.containers {
+		for _, h := range s.harvesters {
+			h.stop()
+		}
+	}
+}
+
+// BootstrapCheck removes registry states of files which no longer exist,
+// and returns input files of containers harvested before restart.
+func (c *nativeConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	if err := c.registry.prune(nil); err != nil {
+		return nil, err
+	}
+	if _, err := configurer.RemoveTempFiles(c.inputDir); err != nil {
+		return nil, fmt.Errorf("error remove temporary files: %v", err)
+	}
+	files, err := ioutil.ReadDir(c.inputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]*configurer.InputConfigFile)
+	for _, f := range files {
+		path := filepath.Join(c.inputDir, f.Name())
+		inputConfig, err := configurer.LoadInput(path)
+		if err == nil && inputConfig.Version != currentInputConfigVersion {
+			err = fmt.Errorf("unknown version %s", inputConfig.Version)
+		}
+		if err != nil {
+			c.logger.Warnf("unable to load input file %s: %v", f.Name(), err)
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		ret[inputConfig.ContainerID] = inputConfig
+	}
+	return ret, nil
+}
+
+// <hash of container ID>.json, see configurer.InputFileName.
+func (c *nativeConfigurer) getInputPath(con *container.Container) string {
+	return filepath.Join(c.inputDir, configurer.InputFileName(con.ID, ".json"))
+}
+
+// loadInput recovers log configs of the container from its input file.
+func (c *nativeConfigurer) loadInput(con *container.Container) (*configurer.ContainerAddEvent, error) {
+	data, err := ioutil.ReadFile(c.getInputPath(con))
+	if err != nil {
+		return nil, err
+	}
+	// skip the header line
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	ret := &configurer.ContainerAddEvent{Container: *con}
+	if err := json.Unmarshal(data, &ret.LogConfigs); err != nil {
+		return nil, fmt.Errorf("error decode input file: %v", err)
+	}
+	return ret, nil
+}
+
+// OnAdd starts harvesting logs of the container. If the container is
+// known, harvesters are restarted from the acknowledged offsets, so lines
+// may be shipped again.
+func (c *nativeConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	redactions, err := compileAllRedactions(ev)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(ev.LogConfigs)
+	if err != nil {
+		return fmt.Errorf("error encode log configs: %v", err)
+	}
+	data, err := configurer.WithHeader(&ev.Container, currentInputConfigVersion, content)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, err := configurer.WriteFileAtomic(c.getInputPath(&ev.Container), data, 0644); err != nil {
+		return fmt.Errorf("error write input file: %v", err)
+	}
+	if old, ok := c.containers[ev.Container.ID]; ok {
+		for _, h := range old.harvesters {
+			h.stop()
+		}
+	}
+	s := &containerState{
+		ContainerAddEvent: ev,
+		redactions:        redactions,
+		harvesters:        make(map[string]*harvester),
+	}
+	c.containers[ev.Container.ID] = s
+	c.harvest(s)
+
+	c.logger.Info("Harvesting started for container", ev.Container.ID)
+	return nil
+}
+
+func compileAllRedactions(ev *configurer.ContainerAddEvent) ([][]redaction, error) {
+	redactions := make([][]redaction, len(ev.LogConfigs))
+	for i, cfg := range ev.LogConfigs {
+		rs, err := compileRedactions(cfg.Redactions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redactions of %s: %v", cfg.Name, err)
+		}
+		redactions[i] = rs
+	}
+	return redactions, nil
+}
+
+// OnDestroy marks the container destroyed, it's removed after all its logs
+// are shipped.
+func (c *nativeConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.containers[ev.Container.ID]
+	if !ok {
+		// The container is destroyed while log-pilot is down, its files are
+		// harvested from the acknowledged offsets in registry.
+		added, err := c.loadInput(&ev.Container)
+		if err != nil {
+			c.logger.Warnf("error load input of %s, its logs are not shipped: %v", ev.Container.ID, err)
+			c.removeInput(&ev.Container)
+			return nil
+		}
+		redactions, err := compileAllRedactions(added)
+		if err != nil {
+			c.logger.Warnf("error load input of %s, its logs are not shipped: %v", ev.Container.ID, err)
+			c.removeInput(&ev.Container)
+			return nil
+		}
+		s = &containerState{
+			ContainerAddEvent: added,
+			redactions:        redactions,
+			harvesters:        make(map[string]*harvester),
+		}
+		c.containers[ev.Container.ID] = s
+		c.harvest(s)
+		c.logger.Info("Harvesting resumed for destroyed container", ev.Container.ID)
+	}
+	if s.destroyed.IsZero() {
+		s.destroyed = time.Now()
+	}
+	return nil
+}
+
+func (c *nativeConfigurer) removeInput(con *container.Container) {
+	if err := os.Remove(c.getInputPath(con)); err != nil && !os.IsNotExist(err) {
+		c.logger.Errorf("error remove input file of %s: %v", con.ID, err)
+	}
+}
+
+// harvest starts harvesters for new files of the container.
+func (c *nativeConfigurer) harvest(s *containerState) {
+	for i, cfg := range s.LogConfigs {
+		files, err := filepath.Glob(cfg.LogFile)
+		if err != nil {
+			c.logger.Errorf("error glob %s: %v", cfg.LogFile, err)
+			continue
+		}
+		for _, path := range files {
+			if _, ok := s.harvesters[path]; ok {
+				continue
+			}
+			h, err := newHarvester(path, cfg, s.redactions[i], c.registry, c.queue, c.logger)
+			if err != nil {
+				c.logger.Errorf("error open %s: %v", path, err)
+				continue
+			}
+			s.harvesters[path] = h
+			go h.run()
+		}
+	}
+}
+
+func (c *nativeConfigurer) watch() {
+	ticker := time.NewTicker(c.watchDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			c.scan()
+		}
+	}
+}
+
+// scan picks up new files of running containers, removes destroyed
+// containers whose files are all shipped or which wait longer than
+// gcMaxWait, and prunes registry states of rotated or removed files.
+func (c *nativeConfigurer) scan() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for id, s := range c.containers {
+		if s.destroyed.IsZero() {
+			c.harvest(s)
+			continue
+		}
+
+		finished := true
+		for _, h := range s.harvesters {
+			if !h.finished() {
+				finished = false
+				break
+			}
+		}
+		expired := c.gcMaxWait > 0 && time.Since(s.destroyed) >= c.gcMaxWait
+		if !finished && !expired {
+			continue
+		}
+
+		keys := make([]string, 0, len(s.harvesters))
+		for _, h := range s.harvesters {
+			h.stop()
+			keys = append(keys, h.key())
+		}
+		delete(c.containers, id)
+		c.removeInput(&s.Container)
+		if err := c.registry.remove(keys); err != nil {
+			c.logger.Errorf("error remove registry states of %s: %v", id, err)
+		}
+		if finished {
+			c.logger.Infof("All logs of container %s are shipped", id)
+		} else {
+			c.logger.Warnf("Logs of container %s are not shipped in %v, give up", id, c.gcMaxWait)
+		}
+	}
+
+	// States of files being read are kept, even if they are removed.
+	active := make(map[string]bool)
+	for _, s := range c.containers {
+		for _, h := range s.harvesters {
+			active[h.key()] = true
+		}
+	}
+	if err := c.registry.prune(active); err != nil {
+		c.logger.Errorf("error prune registry: %v", err)
+	}
+}
+
+// publish ships events in batches.
+func (c *nativeConfigurer) publish() {
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Event, 0, c.batchSize)
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case ev := <-c.queue:
+			batch = append(batch, ev)
+			if len(batch) < c.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if !c.ship(batch) {
+			return
+		}
+		batch = batch[:0]
+	}
+}
+
+// ship publishes the batch until it's accepted, harvesters are blocked by
+// the full queue meanwhile. Events rejected permanently are dropped, and only
+// events rejected temporarily are published again. It returns false if the
+// configurer is stopped.
+func (c *nativeConfigurer) ship(batch []*Event) bool {
+	backoff := time.Second
+	pending := batch
+	for {
+		err := c.output.Publish(pending)
+		if err == nil {
+			break
+		}
+		if perr, ok := err.(*PartialError); ok {
+			if perr.Dropped > 0 {
+				c.logger.Warnf("drop %d events rejected by output: %s", perr.Dropped, perr.Reason)
+			}
+			if len(perr.Retry) == 0 {
+				break
+			}
+			pending = perr.Retry
+		}
+		c.logger.Errorf("error publish %d events: %v", len(pending), err)
+		select {
+		case <-c.closeCh:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	if err := c.registry.ack(batch); err != nil {
+		c.logger.Errorf("error save registry: %v", err)
+	}
+	return true
+}
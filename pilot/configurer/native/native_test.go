@@ -0,0 +1,426 @@
+package native
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+type fakeOutput struct {
+	lock     sync.Mutex
+	messages []string
+	fail     bool
+}
+
+func (o *fakeOutput) Publish(events []*Event) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.fail {
+		return fmt.Errorf("unavailable")
+	}
+	for _, ev := range events {
+		o.messages = append(o.messages, ev.Fields["message"].(string))
+	}
+	return nil
+}
+
+func (o *fakeOutput) wait(t *testing.T, n int) []string {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		o.lock.Lock()
+		got := append([]string(nil), o.messages...)
+		o.lock.Unlock()
+		if len(got) >= n {
+			return got
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d messages", n)
+	return nil
+}
+
+func appendFile(t *testing.T, path, content string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "app.log")
+	appendFile(t, logFile, "a\nb\n")
+
+	out := &fakeOutput{}
+	c, err := newConfigurer("/", Config{DataDir: filepath.Join(dir, "data"), FlushInterval: 10 * time.Millisecond}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Stop()
+
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{
+			{
+				Name:       "app",
+				LogFile:    filepath.Join(dir, "*.log"),
+				Redactions: []configurer.RedactionRule{{Pattern: "secret", Replacement: "***"}},
+			},
+		},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+	out.wait(t, 2)
+
+	// incomplete line is not shipped until it's completed
+	appendFile(t, logFile, "sec")
+	appendFile(t, logFile, "ret\n")
+	if got := out.wait(t, 3); got[2] != "***" {
+		t.Errorf("expect redacted line, got %q", got[2])
+	}
+
+	// rotate
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, logFile, "c\n")
+	if got := out.wait(t, 4); got[3] != "c" {
+		t.Errorf("expect c after rotation, got %v", got)
+	}
+
+	// truncate
+	if err := os.Truncate(logFile, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * checkInterval)
+	appendFile(t, logFile, "d\n")
+	if got := out.wait(t, 5); got[4] != "d" {
+		t.Errorf("expect d after truncation, got %v", got)
+	}
+
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: ev.Container}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		c.scan()
+		c.lock.Lock()
+		n := len(c.containers)
+		c.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("destroyed container is not removed")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := out.wait(t, 5); len(got) != 5 {
+		t.Errorf("expect 5 messages, got %v", got)
+	}
+}
+
+func TestRestartFromAcknowledged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "app.log")
+	appendFile(t, logFile, "a\nb\n")
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, inode := fileID(fi)
+	reg, err := loadRegistry(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.ack([]*Event{{Source: logFile, Device: dev, Inode: inode, Offset: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err = loadRegistry(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := make(chan *Event, 10)
+	h, err := newHarvester(logFile, &configurer.LogConfig{}, nil, reg, queue, logp.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go h.run()
+	defer h.stop()
+
+	select {
+	case ev := <-queue:
+		if ev.Fields["message"] != "b" || ev.Offset != 4 {
+			t.Errorf("expect b at 4, got %v at %d", ev.Fields["message"], ev.Offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestDestroyedWhileDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "app.log")
+	appendFile(t, logFile, "a\nb\n")
+	cfg := Config{DataDir: filepath.Join(dir, "data"), FlushInterval: 10 * time.Millisecond}
+
+	// a is shipped before log-pilot is down
+	c, err := newConfigurer("/", cfg, &fakeOutput{fail: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	con := container.Container{ID: "1", PodID: "uid"}
+	err = c.OnAdd(&configurer.ContainerAddEvent{
+		Container:  con,
+		LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: logFile, Tags: map[string]string{"k": "v"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, inode := fileID(fi)
+	if err := c.registry.ack([]*Event{{Source: logFile, Device: dev, Inode: inode, Offset: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &fakeOutput{}
+	c, err = newConfigurer("/", cfg, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collected, err := c.BootstrapCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := collected[con.ID]
+	if !ok || info.PodID != con.PodID {
+		t.Fatalf("expect input of container %s, got %v", con.ID, collected)
+	}
+	c.Start()
+	defer c.Stop()
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: con}); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.wait(t, 1); len(got) != 1 || got[0] != "b" {
+		t.Errorf("expect b resumed from registry, got %v", got)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		c.scan()
+		c.lock.Lock()
+		n := len(c.containers)
+		c.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("destroyed container is not removed")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err := os.Stat(c.getInputPath(&con)); !os.IsNotExist(err) {
+		t.Errorf("expect input file removed, got %v", err)
+	}
+}
+
+func TestFinishedWithPartialLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "app.log")
+	appendFile(t, logFile, "a\nincomplete")
+	reg, err := loadRegistry(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := make(chan *Event, 10)
+	h, err := newHarvester(logFile, &configurer.LogConfig{}, nil, reg, queue, logp.NewLogger("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.partialTimeout = 2 * checkInterval
+	go h.run()
+	defer h.stop()
+
+	select {
+	case ev := <-queue:
+		if err := reg.ack([]*Event{ev}); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	time.Sleep(checkInterval / 2)
+	if h.finished() {
+		t.Error("expect not finished before the partial line times out")
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !h.finished() {
+		if time.Now().After(deadline) {
+			t.Fatal("expect finished after the partial line times out")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestPruneRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reg, err := loadRegistry(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, name := range []string{"a.log", "b.log", "c.log"} {
+		path := filepath.Join(dir, name)
+		appendFile(t, path, "a\n")
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dev, inode := fileID(fi)
+		if err := reg.ack([]*Event{{Source: path, Device: dev, Inode: inode, Offset: 2}}); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, fileKey(dev, inode))
+	}
+	// a.log is rotated, b.log is removed but still read
+	if err := os.Rename(filepath.Join(dir, "a.log"), filepath.Join(dir, "a.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, filepath.Join(dir, "a.log"), "b\n")
+	if err := os.Remove(filepath.Join(dir, "b.log")); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.prune(map[string]bool{keys[1]: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err = loadRegistry(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reg.states[keys[0]]; ok {
+		t.Error("expect state of rotated file pruned")
+	}
+	if _, ok := reg.states[keys[1]]; !ok {
+		t.Error("expect state of active file kept")
+	}
+	if _, ok := reg.states[keys[2]]; !ok {
+		t.Error("expect state of existing file kept")
+	}
+}
+
+func TestESOutputPartial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors":true,"items":[
+{"index":{"status":201}},
+{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},
+{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+	}))
+	defer srv.Close()
+
+	out, err := NewOutput(OutputConfig{Type: "elasticsearch", Hosts: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*Event
+	for i := 0; i < 3; i++ {
+		events = append(events, &Event{Fields: map[string]interface{}{"message": fmt.Sprint(i)}})
+	}
+	err = out.Publish(events)
+	perr, ok := err.(*PartialError)
+	if !ok {
+		t.Fatalf("expect partial error, got %v", err)
+	}
+	if len(perr.Retry) != 1 || perr.Retry[0] != events[1] || perr.Dropped != 1 {
+		t.Errorf("expect the 429 event retried and the 400 event dropped, got %v", perr)
+	}
+}
+
+func TestESOutput(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		w.Write([]byte(`{"errors":false}`))
+	}))
+	defer srv.Close()
+
+	out, err := NewOutput(OutputConfig{Type: "elasticsearch", Hosts: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &Event{
+		Timestamp: time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+		Fields:    map[string]interface{}{"message": "hello"},
+		OutOpts:   map[string]string{configurer.OutOptIndex: "payment"},
+	}
+	if err := out.Publish([]*Event{ev}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"_index":"logstash-payment-2019.01.02"`) {
+		t.Errorf("unexpected bulk body: %s", body)
+	}
+}
+
+func TestRedactFields(t *testing.T) {
+	rs, err := compileRedactions([]configurer.RedactionRule{{Pattern: "secret", Replacement: "***"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harvester{path: "/a.log", cfg: &configurer.LogConfig{Format: configurer.LogFormatJSON}, redactions: rs}
+	ev := h.event([]byte(`{"msg":"sec\u0072et","ctx":{"token":"secret"},"n":1}`), 0, 0, 0)
+	if ev.Fields["msg"] != "***" {
+		t.Errorf("expect decoded field redacted, got %v", ev.Fields["msg"])
+	}
+	if ctx := ev.Fields["ctx"].(map[string]interface{}); ctx["token"] != "***" {
+		t.Errorf("expect nested field redacted, got %v", ctx["token"])
+	}
+}
@@ -0,0 +1,329 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Event is a log line read from a file.
+type Event struct {
+	Timestamp time.Time
+	Fields    map[string]interface{}
+	// OutOpts routes the event, see configurer.OutOptIndex.
+	OutOpts map[string]string
+
+	// Source, Device, Inode and Offset identify the end of the line in the
+	// file, the offset is acknowledged after the event is shipped.
+	Source string
+	Device uint64
+	Inode  uint64
+	Offset int64
+}
+
+// Output ships events. Publish returns nil only if all events are accepted.
+// A *PartialError means only its Retry events should be published again,
+// other errors mean the whole batch is published again, so an output should
+// be tolerant of duplicates.
+type Output interface {
+	Publish(events []*Event) error
+}
+
+// PartialError is returned by Publish if some events are not accepted.
+// Events which can't be accepted anyway, e.g. mapping conflicts, are dropped.
+type PartialError struct {
+	// Retry are events rejected temporarily, e.g. by 429 or 5xx.
+	Retry   []*Event
+	Dropped int
+	// Reason is the reason of the first failure.
+	Reason string
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d events to retry, %d dropped: %s", len(e.Retry), e.Dropped, e.Reason)
+}
+
+// OutputConfig defines where the events are sent to.
+type OutputConfig struct {
+	// Type is "elasticsearch", "kafka" or "kafka-rest". Hosts are brokers
+	// of kafka, or URLs of a Confluent compatible REST proxy of kafka-rest.
+	Type  string   `yaml:"type"`
+	Hosts []string `yaml:"hosts"`
+	// Index is the elasticsearch index prefix, events are sent to
+	// <index>-<route.index>-<date>.
+	Index string `yaml:"index"`
+	// Topic is the default kafka topic.
+	Topic   string        `yaml:"topic"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// NewOutput creates output by cfg.Type.
+func NewOutput(cfg OutputConfig) (Output, error) {
+	if len(cfg.Hosts) == 0 {
+		return nil, fmt.Errorf("at least one host is required")
+	}
+	client := &http.Client{Timeout: cfg.Timeout}
+	switch cfg.Type {
+	case "elasticsearch":
+		if cfg.Index == "" {
+			cfg.Index = "logstash"
+		}
+		return &esOutput{client: client, hosts: cfg.Hosts, index: cfg.Index}, nil
+	case "kafka":
+		if cfg.Topic == "" {
+			return nil, fmt.Errorf("topic is required by kafka output")
+		}
+		return &kafkaOutput{
+			brokers: cfg.Hosts,
+			topic:   cfg.Topic,
+			timeout: cfg.Timeout,
+			writers: make(map[string]*kafka.Writer),
+		}, nil
+	case "kafka-rest":
+		if cfg.Topic == "" {
+			return nil, fmt.Errorf("topic is required by kafka-rest output")
+		}
+		return &kafkaRESTOutput{client: client, hosts: cfg.Hosts, topic: cfg.Topic}, nil
+	}
+	return nil, fmt.Errorf("unknown output %q", cfg.Type)
+}
+
+// document returns the event as a json object.
+func document(ev *Event) map[string]interface{} {
+	doc := make(map[string]interface{}, len(ev.Fields)+1)
+	for k, v := range ev.Fields {
+		doc[k] = v
+	}
+	doc["@timestamp"] = ev.Timestamp.UTC().Format(time.RFC3339Nano)
+	return doc
+}
+
+// esOutput sends events by elasticsearch bulk API, hosts are tried in turn
+// for every request.
+type esOutput struct {
+	client *http.Client
+	hosts  []string
+	index  string
+	next   int
+}
+
+func (o *esOutput) indexName(ev *Event) string {
+	index := o.index
+	if suffix := ev.OutOpts[configurer.OutOptIndex]; suffix != "" {
+		index += "-" + suffix
+	}
+	return index + "-" + ev.Timestamp.UTC().Format("2006.01.02")
+}
+
+func (o *esOutput) Publish(events []*Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, ev := range events {
+		meta := map[string]interface{}{"_index": o.indexName(ev)}
+		if pipeline := ev.OutOpts[configurer.OutOptPipeline]; pipeline != "" {
+			meta["pipeline"] = pipeline
+		}
+		if err := enc.Encode(map[string]interface{}{"index": meta}); err != nil {
+			return err
+		}
+		if err := enc.Encode(document(ev)); err != nil {
+			return err
+		}
+	}
+
+	host := strings.TrimSuffix(o.hosts[o.next%len(o.hosts)], "/")
+	o.next++
+	resp, err := o.client.Post(host+"/_bulk", "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error decode bulk response: %v", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	if len(result.Items) != len(events) {
+		return fmt.Errorf("expect %d items in bulk response of %s, got %d", len(events), host, len(result.Items))
+	}
+
+	// Items are in the order of requests, each has only the "index" action.
+	perr := &PartialError{}
+	for i, item := range result.Items {
+		for _, r := range item {
+			if r.Status/100 == 2 {
+				continue
+			}
+			if perr.Reason == "" {
+				perr.Reason = fmt.Sprintf("status %d from %s: %s", r.Status, host, r.Error)
+			}
+			if r.Status == http.StatusTooManyRequests || r.Status >= 500 {
+				perr.Retry = append(perr.Retry, events[i])
+			} else {
+				perr.Dropped++
+			}
+		}
+	}
+	if perr.Reason == "" {
+		return nil
+	}
+	return perr
+}
+
+// eventTopic returns the topic of the event, it's topic if the event is not
+// routed.
+func eventTopic(ev *Event, topic string) string {
+	if t := ev.OutOpts[configurer.OutOptTopic]; t != "" {
+		return t
+	}
+	return topic
+}
+
+// kafkaBatchTimeout is how long a partition waits for more messages, writes
+// are synchronous, so it delays every Publish.
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// kafkaOutput produces events to kafka brokers, there is a writer for each
+// topic. Writes are synchronous and acknowledged by all in-sync replicas,
+// so offsets are acknowledged only after events are stored.
+type kafkaOutput struct {
+	brokers []string
+	topic   string
+	timeout time.Duration
+	writers map[string]*kafka.Writer
+}
+
+func (o *kafkaOutput) writer(topic string) *kafka.Writer {
+	w, ok := o.writers[topic]
+	if !ok {
+		w = kafka.NewWriter(kafka.WriterConfig{
+			Brokers:      o.brokers,
+			Topic:        topic,
+			Balancer:     &kafka.LeastBytes{},
+			BatchTimeout: kafkaBatchTimeout,
+			WriteTimeout: o.timeout,
+			RequiredAcks: -1,
+		})
+		o.writers[topic] = w
+	}
+	return w
+}
+
+func (o *kafkaOutput) Publish(events []*Event) error {
+	msgs := make(map[string][]kafka.Message)
+	var topics []string
+	for _, ev := range events {
+		value, err := json.Marshal(document(ev))
+		if err != nil {
+			return err
+		}
+		topic := eventTopic(ev, o.topic)
+		if _, ok := msgs[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		msgs[topic] = append(msgs[topic], kafka.Message{Value: value})
+	}
+
+	for _, topic := range topics {
+		ctx, cancel := context.Background(), func() {}
+		if o.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		}
+		err := o.writer(topic).WriteMessages(ctx, msgs[topic]...)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("error produce to %s: %v", topic, err)
+		}
+	}
+	return nil
+}
+
+// kafkaRESTOutput sends events to kafka through a Confluent compatible REST
+// proxy, it's used if brokers are not reachable from nodes.
+type kafkaRESTOutput struct {
+	client *http.Client
+	hosts  []string
+	topic  string
+	next   int
+}
+
+func (o *kafkaRESTOutput) Publish(events []*Event) error {
+	records := make(map[string][]interface{})
+	var topics []string
+	for _, ev := range events {
+		topic := eventTopic(ev, o.topic)
+		if _, ok := records[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		records[topic] = append(records[topic], map[string]interface{}{"value": document(ev)})
+	}
+
+	for _, topic := range topics {
+		if err := o.produce(topic, records[topic]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *kafkaRESTOutput) produce(topic string, records []interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	host := strings.TrimSuffix(o.hosts[o.next%len(o.hosts)], "/")
+	o.next++
+	resp, err := o.client.Post(host+"/topics/"+topic, "application/vnd.kafka.json.v2+json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	var result struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("error decode produce response: %v", err)
+	}
+	for _, o := range result.Offsets {
+		if o.Error != "" {
+			return fmt.Errorf("error produce to %s: %s", topic, o.Error)
+		}
+	}
+	return nil
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("unexpected status %s: %s", resp.Status, msg)
+}
@@ -0,0 +1,161 @@
+package native
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// FileState is the shipped offset of a file, files are identified by device
+// and inode, so renamed files are not read again.
+type FileState struct {
+	Source string `json:"source"`
+	Offset int64  `json:"offset"`
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+}
+
+func (s *FileState) key() string {
+	return fileKey(s.Device, s.Inode)
+}
+
+func fileKey(dev, inode uint64) string {
+	return fmt.Sprintf("%d-%d", dev, inode)
+}
+
+// fileID returns device and inode of the file.
+func fileID(fi os.FileInfo) (dev, inode uint64) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Ino)
+	}
+	return 0, 0
+}
+
+// registry stores offsets which are acknowledged by output.
+type registry struct {
+	path   string
+	states map[string]*FileState
+	lock   sync.Mutex
+}
+
+func loadRegistry(path string) (*registry, error) {
+	r := &registry{
+		path:   path,
+		states: make(map[string]*FileState),
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return r, nil
+		}
+		return nil, err
+	}
+	var states []*FileState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("error decode registry: %v", err)
+	}
+	for _, s := range states {
+		r.states[s.key()] = s
+	}
+	return r, nil
+}
+
+// offset returns acknowledged offset of the file.
+func (r *registry) offset(dev, inode uint64) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s, ok := r.states[fileKey(dev, inode)]; ok {
+		return s.Offset
+	}
+	return 0
+}
+
+// ack updates offsets with shipped events, and persists the registry.
+func (r *registry) ack(events []*Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, ev := range events {
+		key := fileKey(ev.Device, ev.Inode)
+		s, ok := r.states[key]
+		if !ok {
+			s = &FileState{Source: ev.Source, Device: ev.Device, Inode: ev.Inode}
+			r.states[key] = s
+		}
+		if ev.Offset > s.Offset {
+			s.Offset = ev.Offset
+		}
+		s.Source = ev.Source
+	}
+	return r.flush()
+}
+
+// remove deletes states of files, and persists the registry.
+func (r *registry) remove(keys []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, key := range keys {
+		delete(r.states, key)
+	}
+	return r.flush()
+}
+
+// prune removes states of files which are removed or rotated, i.e. no file
+// of the same device and inode is at the source. States in active are kept.
+// The registry is persisted only if anything is removed.
+func (r *registry) prune(active map[string]bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	removed := false
+	for key, s := range r.states {
+		if active[key] {
+			continue
+		}
+		if fi, err := os.Stat(s.Source); err == nil {
+			if dev, inode := fileID(fi); fileKey(dev, inode) == key {
+				continue
+			}
+		}
+		delete(r.states, key)
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return r.flush()
+}
+
+// flush writes registry to a temporary file and renames it, so the registry
+// is never half written.
+func (r *registry) flush() error {
+	states := make([]*FileState, 0, len(r.states))
+	for _, s := range r.states {
+		states = append(states, s)
+	}
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), ".registry")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
@@ -0,0 +1,42 @@
+package otel
+
+import (
+	"flag"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+// Config is the config section of otel configurer.
+type Config struct {
+	ConfigDir string `yaml:"configDir"`
+	// Storage is the file_storage extension defined in the main config,
+	// StorageDir is its directory.
+	Storage    string `yaml:"storage"`
+	StorageDir string `yaml:"storageDir"`
+	// Exporters and Processors are used by logs pipelines.
+	Exporters  configurer.StringList `yaml:"exporters"`
+	Processors configurer.StringList `yaml:"processors"`
+}
+
+func init() {
+	configurer.Register("otel", configurer.Factory{
+		Config: func() interface{} {
+			return &Config{
+				Exporters:  configurer.StringList{"otlp"},
+				Processors: configurer.StringList{"batch"},
+			}
+		},
+		Flags: func(fs *flag.FlagSet, cfg interface{}) {
+			c := cfg.(*Config)
+			fs.StringVar(&c.ConfigDir, "path.otel-config-dir", "", "Directory of OpenTelemetry Collector config fragments")
+			fs.StringVar(&c.Storage, "otel.storage", "file_storage", "file_storage extension which filelog receivers store offsets by")
+			fs.StringVar(&c.StorageDir, "path.otel-storage-dir", "", "Directory of the file_storage extension, offsets are read from it")
+			fs.Var(&c.Exporters, "otel.exporters", "Exporters of OpenTelemetry Collector logs pipelines")
+			fs.Var(&c.Processors, "otel.processors", "Processors of OpenTelemetry Collector logs pipelines")
+		},
+		New: func(opts *configurer.Options, cfg interface{}) (configurer.Configurer, error) {
+			c := cfg.(*Config)
+			return New(opts.BaseDir, c.ConfigDir, c.Storage, c.StorageDir, c.Exporters, c.Processors)
+		},
+	})
+}
@@ -0,0 +1,394 @@
+package otel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+// resourceAttributes maps tags to OpenTelemetry resource attributes, other
+// tags are added as log attributes.
+var resourceAttributes = map[string]string{
+	"kubernetes.pod_name":       "k8s.pod.name",
+	"kubernetes.namespace_name": "k8s.namespace.name",
+	"kubernetes.container_name": "k8s.container.name",
+	"node_name":                 "k8s.node.name",
+}
+
+type otelConfigurer struct {
+	name string
+	base string
+	// configDir contains a config file for each container, every file is a
+	// complete collector config fragment with receivers and a logs pipeline,
+	// the collector should be started with all of them:
+	//   otelcol --config <configDir>/a.yaml --config <configDir>/b.yaml
+	configDir  string
+	exporters  []string
+	processors []string
+	// storage is the file_storage extension defined in the main collector
+	// config, filelog receivers persist offsets by it, so files are not
+	// shipped again after the collector restarts.
+	storage string
+	// storageDir is directory of the storage extension, offsets are read
+	// from it for garbage collection.
+	storageDir    string
+	closeCh       chan bool
+	watchDuration time.Duration
+	// containers are running containers, keyed by container ID.
+	containers map[string]*configurer.ContainerAddEvent
+	// watchContainer are destroyed containers whose logs may be not read yet.
+	watchContainer map[string]*configurer.ContainerAddEvent
+	logger         log.Logger
+	lock           sync.Mutex
+}
+
+// New creates a new OpenTelemetry Collector configurer. Logs pipelines send
+// logs to exporters through processors, and filelog receivers store offsets
+// by the storage extension, they should be defined in the main collector
+// config.
+func New(baseDir, configDir, storage, storageDir string, exporters, processors []string) (configurer.Configurer, error) {
+	if len(exporters) == 0 {
+		return nil, fmt.Errorf("at least one exporter is required")
+	}
+	if storage == "" || storageDir == "" {
+		return nil, fmt.Errorf("storage extension and its directory are required")
+	}
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, err
+	}
+
+	logger := log.NewLogger("configurer")
+	return &otelConfigurer{
+		logger:         logger,
+		name:           "otel",
+		base:           baseDir,
+		configDir:      configDir,
+		exporters:      exporters,
+		processors:     processors,
+		storage:        storage,
+		storageDir:     storageDir,
+		closeCh:        make(chan bool),
+		watchDuration:  60 * time.Second,
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		watchContainer: make(map[string]*configurer.ContainerAddEvent),
+	}, nil
+}
+
+func (c *otelConfigurer) Name() string {
+	return c.name
+}
+
+func (c *otelConfigurer) Start() error {
+	go func() {
+		if err := c.watch(); err != nil {
+			c.logger.Errorf("error watch: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (c *otelConfigurer) Stop() {
+	close(c.closeCh)
+}
+
+// BootstrapCheck removes unknown files and old version files, and returns
+// all the config files.
+func (c *otelConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	if _, err := configurer.RemoveTempFiles(c.configDir); err != nil {
+		return nil, fmt.Errorf("error remove temporary files: %v", err)
+	}
+	files, err := ioutil.ReadDir(c.configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	toRemove := []string{}
+	ret := make(map[string]*configurer.InputConfigFile)
+	for i := range files {
+		base := files[i].Name()
+		inputConfig, err := configurer.LoadInput(filepath.Join(c.configDir, base))
+		if err != nil {
+			log.Warnf("unable to load input config %s: %v", base, err)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		if inputConfig.Version != currentInputConfigVersion {
+			log.Infof("old version: %s", base)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		ret[inputConfig.ContainerID] = inputConfig
+	}
+
+	for _, base := range toRemove {
+		if err := os.Remove(filepath.Join(c.configDir, base)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// <hash of container ID>.yaml, see configurer.InputFileName.
+func (c *otelConfigurer) getContainerConfigPath(con *container.Container) string {
+	return filepath.Join(c.configDir, configurer.InputFileName(con.ID, ".yaml"))
+}
+
+func (c *otelConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	if err := configurer.RejectRedactions(c.Name(), ev); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, err := yaml.Marshal(c.config(ev))
+	if err != nil {
+		return fmt.Errorf("error encode config: %v", err)
+	}
+
+	confPath := c.getContainerConfigPath(&ev.Container)
+	data, err := configurer.WithHeader(&ev.Container, currentInputConfigVersion, content)
+	if err != nil {
+		return err
+	}
+	if _, err := configurer.WriteFileAtomic(confPath, data, 0644); err != nil {
+		return fmt.Errorf("error write config file: %v", err)
+	}
+	c.containers[ev.Container.ID] = ev
+
+	c.logger.Info("Configuration updated successfully for container", ev.Container.ID)
+	return nil
+}
+
+var invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// config generates a filelog receiver for each log source, and a logs
+// pipeline for the container.
+func (c *otelConfigurer) config(ev *configurer.ContainerAddEvent) map[string]interface{} {
+	receivers := map[string]interface{}{}
+	var ids []string
+	for _, cfg := range ev.LogConfigs {
+		id := receiverID(&ev.Container, cfg)
+		ids = append(ids, id)
+		r := receiver(cfg)
+		r["storage"] = c.storage
+		receivers[id] = r
+	}
+
+	pipeline := map[string]interface{}{
+		"receivers": ids,
+		"exporters": c.exporters,
+	}
+	if len(c.processors) > 0 {
+		pipeline["processors"] = c.processors
+	}
+	return map[string]interface{}{
+		"receivers": receivers,
+		"service": map[string]interface{}{
+			"pipelines": map[string]interface{}{
+				"logs/" + invalidIDChars.ReplaceAllString(ev.Container.ID, "_"): pipeline,
+			},
+		},
+	}
+}
+
+func receiverID(con *container.Container, cfg *configurer.LogConfig) string {
+	return "filelog/" + invalidIDChars.ReplaceAllString(con.ID+"_"+cfg.Name, "_")
+}
+
+func receiver(cfg *configurer.LogConfig) map[string]interface{} {
+	resource := map[string]string{}
+	attributes := map[string]string{}
+	for k, v := range cfg.Tags {
+		if attr, ok := resourceAttributes[k]; ok {
+			resource[attr] = v
+		} else {
+			attributes[k] = v
+		}
+	}
+	for k, v := range cfg.OutOpts {
+		attributes[k] = v
+	}
+
+	ret := map[string]interface{}{
+		"include":           []string{cfg.LogFile},
+		"start_at":          "beginning",
+		"include_file_path": true,
+		"resource":          resource,
+		"attributes":        attributes,
+	}
+	if ops := operators(cfg); len(ops) > 0 {
+		ret["operators"] = ops
+	}
+	return ret
+}
+
+// operators parses docker json envelope of stdout and json logs.
+func operators(cfg *configurer.LogConfig) []map[string]interface{} {
+	var ret []map[string]interface{}
+	if cfg.Stdout {
+		ret = append(ret,
+			map[string]interface{}{
+				"type": "json_parser",
+				"timestamp": map[string]interface{}{
+					"parse_from":  "attributes.time",
+					"layout_type": "gotime",
+					"layout":      "2006-01-02T15:04:05.999999999Z07:00",
+				},
+			},
+			map[string]interface{}{
+				"type": "move",
+				"from": "attributes.log",
+				"to":   "body",
+			},
+			map[string]interface{}{
+				"type": "move",
+				"from": "attributes.stream",
+				"to":   `attributes["log.iostream"]`,
+			},
+		)
+	}
+	if cfg.Format == configurer.LogFormatJSON {
+		ret = append(ret, map[string]interface{}{
+			"type":       "json_parser",
+			"parse_from": "body",
+			"on_error":   "send",
+		})
+	}
+	return ret
+}
+
+func (c *otelConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	added, ok := c.containers[ev.Container.ID]
+	if !ok {
+		// Logs added before restart are unknown, they are recovered from the
+		// config file, so offsets can be looked up by receiver id.
+		var err error
+		added, err = c.loadInput(&ev.Container)
+		if err != nil {
+			c.logger.Warnf("error load log config of %s, remove it in next scan: %v", ev.Container.ID, err)
+			added = &configurer.ContainerAddEvent{Container: ev.Container}
+		}
+	}
+	delete(c.containers, ev.Container.ID)
+	c.watchContainer[ev.Container.ID] = added
+	return nil
+}
+
+// loadInput recovers log configs of the container from its config file,
+// only names and log files are recovered.
+func (c *otelConfigurer) loadInput(con *container.Container) (*configurer.ContainerAddEvent, error) {
+	data, err := ioutil.ReadFile(c.getContainerConfigPath(con))
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Receivers map[string]struct {
+			Include []string `yaml:"include"`
+		} `yaml:"receivers"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error decode config file: %v", err)
+	}
+
+	ret := &configurer.ContainerAddEvent{Container: *con}
+	prefix := receiverID(con, &configurer.LogConfig{})
+	for id, r := range cfg.Receivers {
+		if !strings.HasPrefix(id, prefix) || len(r.Include) != 1 {
+			return nil, fmt.Errorf("unexpected receiver %s", id)
+		}
+		ret.LogConfigs = append(ret.LogConfigs, &configurer.LogConfig{
+			Name:    strings.TrimPrefix(id, prefix),
+			LogFile: r.Include[0],
+		})
+	}
+	return ret, nil
+}
+
+func (c *otelConfigurer) watch() error {
+	c.logger.Infof("%s watcher start", c.Name())
+	for {
+		select {
+		case <-c.closeCh:
+			c.logger.Infof("%s watcher stop", c.Name())
+			return nil
+		case <-time.After(c.watchDuration):
+			c.logger.Infof("%s watcher scan", c.Name())
+
+			startTs := time.Now()
+			c.scan()
+			c.logger.Debugf("cost %v to complete scan", time.Since(startTs))
+		}
+	}
+}
+
+// scan gc for config files
+func (c *otelConfigurer) scan() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for id, ev := range c.watchContainer {
+		confPath := c.getContainerConfigPath(&ev.Container)
+		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
+			c.logger.Infof("log config of %s has been removed and ignore", id)
+			delete(c.watchContainer, id)
+			continue
+		}
+		done, err := c.readToEnd(ev)
+		if err != nil {
+			c.logger.Warnf("error check offsets of %s: %v", id, err)
+			continue
+		}
+		if !done {
+			c.logger.Debugf("logs of %s are not read to the end, will try to remove it in next scan", id)
+			continue
+		}
+		c.logger.Infof("try to remove log config of %s", id)
+		if err := os.Remove(confPath); err != nil {
+			c.logger.Errorf("remove log config of %s fail: %v", id, err)
+		} else {
+			delete(c.watchContainer, id)
+		}
+	}
+}
+
+// readToEnd checks whether all files of the container are read to the end
+// according to offsets persisted by the storage extension. Files which don't
+// exist any more are considered done.
+func (c *otelConfigurer) readToEnd(ev *configurer.ContainerAddEvent) (bool, error) {
+	for _, cfg := range ev.LogConfigs {
+		offsets, err := c.loadOffsets(receiverID(&ev.Container, cfg))
+		if err != nil {
+			return false, err
+		}
+		files, err := filepath.Glob(cfg.LogFile)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range files {
+			fi, err := os.Stat(f)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			if offset := offsets[f]; offset < fi.Size() {
+				c.logger.Debugf("%s is read to %d of %d", f, offset, fi.Size())
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
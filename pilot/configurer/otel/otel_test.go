@@ -0,0 +1,126 @@
+package otel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/elastic/beats/libbeat/logp"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestConfig(t *testing.T) {
+	c := &otelConfigurer{
+		exporters: []string{"otlp"},
+		storage:   "file_storage",
+	}
+	ev := &configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{
+			{
+				Name:    "stdout",
+				LogFile: "/var/lib/docker/containers/1/1-json.log",
+				Format:  configurer.LogFormatJSON,
+				Stdout:  true,
+				Tags:    map[string]string{"kubernetes.pod_name": "tomcat", "foo": "bar"},
+			},
+		},
+	}
+	cfg := c.config(ev)
+	r, ok := cfg["receivers"].(map[string]interface{})["filelog/1_stdout"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expect receiver filelog/1_stdout, got %v", cfg["receivers"])
+	}
+	if r["storage"] != "file_storage" {
+		t.Errorf("expect offsets stored by file_storage, got %v", r["storage"])
+	}
+	if r["resource"].(map[string]string)["k8s.pod.name"] != "tomcat" {
+		t.Errorf("expect k8s.pod.name resource attribute, got %v", r["resource"])
+	}
+	if r["attributes"].(map[string]string)["foo"] != "bar" {
+		t.Errorf("expect foo attribute, got %v", r["attributes"])
+	}
+	if n := len(r["operators"].([]map[string]interface{})); n != 4 {
+		t.Errorf("expect 4 operators, got %d", n)
+	}
+}
+
+func TestReadToEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(logFile, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &otelConfigurer{
+		configDir:      dir,
+		exporters:      []string{"otlp"},
+		storage:        "file_storage",
+		storageDir:     dir,
+		logger:         logp.NewLogger("test"),
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		watchContainer: make(map[string]*configurer.ContainerAddEvent),
+	}
+	con := container.Container{ID: "1", Namespace: "default", Pod: "app", Name: "app"}
+	ev := &configurer.ContainerAddEvent{
+		Container:  con,
+		LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: logFile}},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarted, log configs are recovered from the config file
+	c.containers = make(map[string]*configurer.ContainerAddEvent)
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: con}); err != nil {
+		t.Fatal(err)
+	}
+	if added := c.watchContainer["1"]; len(added.LogConfigs) != 1 || added.LogConfigs[0].LogFile != logFile {
+		t.Fatalf("expect log configs recovered, got %+v", added)
+	}
+
+	writeOffset := func(offset int64) {
+		db, err := bolt.Open(c.storageFile(receiverID(&con, ev.LogConfigs[0])), 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		value := fmt.Sprintf(`1
+{"Fingerprint":{"first_bytes":"aGVsbG8K"},"Offset":%d,"FileAttributes":{"log.file.path":%q}}
+`, offset, logFile)
+		err = db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(storageBucket)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("file_input."+knownFilesKey), []byte(value))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.scan()
+	if _, err := os.Stat(c.getContainerConfigPath(&con)); err != nil {
+		t.Fatalf("expect config kept without offsets: %v", err)
+	}
+	writeOffset(3)
+	c.scan()
+	if _, err := os.Stat(c.getContainerConfigPath(&con)); err != nil {
+		t.Fatalf("expect config kept: %v", err)
+	}
+	writeOffset(6)
+	c.scan()
+	if _, err := os.Stat(c.getContainerConfigPath(&con)); !os.IsNotExist(err) {
+		t.Errorf("expect config removed, got %v", err)
+	}
+}
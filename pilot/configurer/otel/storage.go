@@ -0,0 +1,99 @@
+package otel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// storageBucket is the bucket of file_storage extension clients.
+var storageBucket = []byte("default")
+
+// knownFilesKey ends the key of filelog offsets, the key is prefixed by id
+// of the file input operator, e.g. file_input.knownFiles.
+const knownFilesKey = "knownFiles"
+
+// fileAttrPath is the attribute added by include_file_path.
+const fileAttrPath = "log.file.path"
+
+// storageFile returns the db file of a receiver created by file_storage
+// extension, e.g. receiver_filelog_1_stdout for filelog/1_stdout.
+func (c *otelConfigurer) storageFile(receiverID string) string {
+	return filepath.Join(c.storageDir, "receiver_"+strings.Replace(receiverID, "/", "_", 1))
+}
+
+// loadOffsets reads offsets of files persisted by the receiver, keyed by
+// file path. The db is locked by the collector, so a copy of it is read.
+func (c *otelConfigurer) loadOffsets(receiverID string) (map[string]int64, error) {
+	ret := make(map[string]int64)
+	src, err := os.Open(c.storageFile(receiverID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return nil, err
+	}
+	defer src.Close()
+
+	tmp, err := ioutil.TempFile("", "otel-storage")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error copy storage: %v", err)
+	}
+
+	db, err := bolt.Open(tmp.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error open storage: %v", err)
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(storageBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if !bytes.HasSuffix(k, []byte(knownFilesKey)) {
+				return nil
+			}
+			return parseKnownFiles(v, ret)
+		})
+	})
+	return ret, err
+}
+
+// parseKnownFiles parses offsets saved by filelog receiver, which are json
+// encoded count of files followed by metadata of each file.
+func parseKnownFiles(data []byte, offsets map[string]int64) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		return fmt.Errorf("error decode count of known files: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		var md struct {
+			Offset         int64
+			FileAttributes map[string]interface{}
+		}
+		if err := dec.Decode(&md); err != nil {
+			return fmt.Errorf("error decode known file: %v", err)
+		}
+		path, _ := md.FileAttributes[fileAttrPath].(string)
+		if path != "" && md.Offset > offsets[path] {
+			offsets[path] = md.Offset
+		}
+	}
+	return nil
+}
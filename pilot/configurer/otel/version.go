@@ -0,0 +1,9 @@
+package otel
+
+const (
+	inputConfigVersionV0_1 = "v0.1"
+)
+
+var (
+	currentInputConfigVersion = inputConfigVersionV0_1
+)
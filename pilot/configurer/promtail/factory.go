@@ -0,0 +1,37 @@
+package promtail
+
+import (
+	"flag"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+// Config is the config section of promtail configurer.
+type Config struct {
+	SDDir         string `yaml:"sdDir"`
+	PositionsFile string `yaml:"positionsFile"`
+	// Labels are tags which become loki labels.
+	Labels configurer.StringList `yaml:"labels"`
+}
+
+func init() {
+	configurer.Register("promtail", configurer.Factory{
+		Config: func() interface{} {
+			return &Config{
+				Labels: configurer.StringList{
+					"kubernetes.namespace_name", "kubernetes.pod_name", "kubernetes.container_name", "node_name",
+				},
+			}
+		},
+		Flags: func(fs *flag.FlagSet, cfg interface{}) {
+			c := cfg.(*Config)
+			fs.StringVar(&c.SDDir, "path.promtail-sd-dir", "", "Directory of promtail file_sd targets")
+			fs.StringVar(&c.PositionsFile, "path.promtail-positions", "", "Promtail positions file path")
+			fs.Var(&c.Labels, "promtail.labels", "Tags which become loki labels")
+		},
+		New: func(opts *configurer.Options, cfg interface{}) (configurer.Configurer, error) {
+			c := cfg.(*Config)
+			return New(opts.BaseDir, c.SDDir, c.PositionsFile, c.Labels)
+		},
+	})
+}
@@ -0,0 +1,345 @@
+package promtail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Positions is the content of promtail positions file.
+type Positions struct {
+	Positions map[string]string `yaml:"positions"`
+}
+
+// Target is a file_sd target group.
+type Target struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+type promtailConfigurer struct {
+	name string
+	base string
+	// sdDir contains file_sd files, promtail should be configured with:
+	//   file_sd_configs:
+	//   - files: ["<sdDir>/*.yml"]
+	sdDir         string
+	positionsFile string
+	// labels is the allow-list of tags which become loki labels.
+	labels map[string]struct{}
+	// dropped are tags not in labels which are logged already.
+	dropped       map[string]struct{}
+	closeCh       chan bool
+	watchDuration time.Duration
+	// containers are running containers, keyed by container ID.
+	containers map[string]*configurer.ContainerAddEvent
+	// watchContainer are destroyed containers whose logs may be not read yet.
+	watchContainer map[string]*configurer.ContainerAddEvent
+	logger         log.Logger
+	lock           sync.Mutex
+}
+
+// New creates a new promtail configurer. Only tags in labels are added to
+// targets as loki labels, to avoid high cardinality. Other tags are not
+// shipped, they are logged once for each name.
+func New(baseDir, sdDir, positionsFile string, labels []string) (configurer.Configurer, error) {
+	if err := os.MkdirAll(sdDir, 0755); err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]struct{})
+	for _, l := range labels {
+		allowed[l] = struct{}{}
+	}
+
+	logger := log.NewLogger("configurer")
+	return &promtailConfigurer{
+		logger:         logger,
+		name:           "promtail",
+		base:           baseDir,
+		sdDir:          sdDir,
+		positionsFile:  positionsFile,
+		labels:         allowed,
+		dropped:        make(map[string]struct{}),
+		closeCh:        make(chan bool),
+		watchDuration:  60 * time.Second,
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		watchContainer: make(map[string]*configurer.ContainerAddEvent),
+	}, nil
+}
+
+func (c *promtailConfigurer) Name() string {
+	return c.name
+}
+
+func (c *promtailConfigurer) Start() error {
+	go func() {
+		if err := c.watch(); err != nil {
+			c.logger.Errorf("error watch: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (c *promtailConfigurer) Stop() {
+	close(c.closeCh)
+}
+
+// BootstrapCheck removes unknown files and old version files, and returns
+// all the target files.
+func (c *promtailConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	if _, err := configurer.RemoveTempFiles(c.sdDir); err != nil {
+		return nil, fmt.Errorf("error remove temporary files: %v", err)
+	}
+	files, err := ioutil.ReadDir(c.sdDir)
+	if err != nil {
+		return nil, err
+	}
+
+	toRemove := []string{}
+	ret := make(map[string]*configurer.InputConfigFile)
+	for i := range files {
+		base := files[i].Name()
+		inputConfig, err := configurer.LoadInput(filepath.Join(c.sdDir, base))
+		if err != nil {
+			log.Warnf("unable to load target file %s: %v", base, err)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		if inputConfig.Version != currentInputConfigVersion {
+			log.Infof("old version: %s", base)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		ret[inputConfig.ContainerID] = inputConfig
+	}
+
+	for _, base := range toRemove {
+		if err := os.Remove(filepath.Join(c.sdDir, base)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// <hash of container ID>.yml, see configurer.InputFileName.
+func (c *promtailConfigurer) getContainerConfigPath(con *container.Container) string {
+	return filepath.Join(c.sdDir, configurer.InputFileName(con.ID, ".yml"))
+}
+
+func (c *promtailConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	if err := configurer.RejectRedactions(c.Name(), ev); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, err := yaml.Marshal(c.targets(ev))
+	if err != nil {
+		return fmt.Errorf("error encode targets: %v", err)
+	}
+
+	confPath := c.getContainerConfigPath(&ev.Container)
+	data, err := configurer.WithHeader(&ev.Container, currentInputConfigVersion, content)
+	if err != nil {
+		return err
+	}
+	if _, err := configurer.WriteFileAtomic(confPath, data, 0644); err != nil {
+		return fmt.Errorf("error write target file: %v", err)
+	}
+	c.containers[ev.Container.ID] = ev
+
+	c.logger.Info("Targets updated successfully for container", ev.Container.ID)
+	return nil
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// labelName converts tag key to loki label name, e.g.
+// kubernetes.pod_name -> kubernetes_pod_name
+func labelName(key string) string {
+	name := invalidLabelChars.ReplaceAllString(key, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// targets generates a target group for each log source, the log file is
+// set to __path__ label.
+func (c *promtailConfigurer) targets(ev *configurer.ContainerAddEvent) []Target {
+	var ret []Target
+	for _, cfg := range ev.LogConfigs {
+		labels := map[string]string{
+			"__path__": cfg.LogFile,
+		}
+		for k, v := range cfg.Tags {
+			if _, ok := c.labels[k]; ok {
+				labels[labelName(k)] = v
+				continue
+			}
+			if _, ok := c.dropped[k]; !ok {
+				c.dropped[k] = struct{}{}
+				c.logger.Infof("tag %s is not in promtail.labels, it's not shipped", k)
+			}
+		}
+		ret = append(ret, Target{
+			Targets: []string{"localhost"},
+			Labels:  labels,
+		})
+	}
+	return ret
+}
+
+func (c *promtailConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	added, ok := c.containers[ev.Container.ID]
+	if !ok {
+		// Logs added before restart are unknown, they are recovered from the
+		// target file, so positions can be looked up by log files.
+		var err error
+		added, err = c.loadInput(&ev.Container)
+		if err != nil {
+			c.logger.Warnf("error load targets of %s, remove it in next scan: %v", ev.Container.ID, err)
+			added = &configurer.ContainerAddEvent{Container: ev.Container}
+		}
+	}
+	delete(c.containers, ev.Container.ID)
+	c.watchContainer[ev.Container.ID] = added
+	return nil
+}
+
+// loadInput recovers log configs of the container from its target file,
+// only log files are recovered from __path__ labels.
+func (c *promtailConfigurer) loadInput(con *container.Container) (*configurer.ContainerAddEvent, error) {
+	data, err := ioutil.ReadFile(c.getContainerConfigPath(con))
+	if err != nil {
+		return nil, err
+	}
+	var targets []Target
+	if err := yaml.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("error decode target file: %v", err)
+	}
+
+	ret := &configurer.ContainerAddEvent{Container: *con}
+	for _, t := range targets {
+		path, ok := t.Labels["__path__"]
+		if !ok {
+			return nil, fmt.Errorf("target without __path__: %v", t.Labels)
+		}
+		ret.LogConfigs = append(ret.LogConfigs, &configurer.LogConfig{LogFile: path})
+	}
+	return ret, nil
+}
+
+func (c *promtailConfigurer) watch() error {
+	c.logger.Infof("%s watcher start", c.Name())
+	for {
+		select {
+		case <-c.closeCh:
+			c.logger.Infof("%s watcher stop", c.Name())
+			return nil
+		case <-time.After(c.watchDuration):
+			c.logger.Infof("%s watcher scan", c.Name())
+
+			startTs := time.Now()
+			err := c.scan()
+			c.logger.Debugf("cost %v to complete scan", time.Since(startTs))
+			if err != nil {
+				c.logger.Errorf("%s watcher scan error: %v", c.Name(), err)
+			}
+		}
+	}
+}
+
+// scan gc for target files
+func (c *promtailConfigurer) scan() error {
+	positions, err := c.getPositions()
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for id, ev := range c.watchContainer {
+		confPath := c.getContainerConfigPath(&ev.Container)
+		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
+			c.logger.Infof("target file of %s has been removed and ignore", id)
+			delete(c.watchContainer, id)
+			continue
+		}
+		if !c.readToEnd(ev, positions) {
+			c.logger.Debugf("logs of %s are not read to the end, will try to remove it in next scan", id)
+			continue
+		}
+		c.logger.Infof("try to remove target file of %s", id)
+		if err := os.Remove(confPath); err != nil {
+			c.logger.Errorf("remove target file of %s fail: %v", id, err)
+		} else {
+			delete(c.watchContainer, id)
+		}
+	}
+	return nil
+}
+
+// readToEnd checks whether all files of the container are read to the end
+// according to positions. Files which don't exist any more are considered done.
+func (c *promtailConfigurer) readToEnd(ev *configurer.ContainerAddEvent, positions map[string]int64) bool {
+	for _, cfg := range ev.LogConfigs {
+		files, err := filepath.Glob(cfg.LogFile)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			fi, err := os.Stat(f)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			if pos, ok := positions[f]; !ok || pos < fi.Size() {
+				c.logger.Debugf("%s is read to %d of %d", f, pos, fi.Size())
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c *promtailConfigurer) getPositions() (map[string]int64, error) {
+	data, err := ioutil.ReadFile(c.positionsFile)
+	if err != nil {
+		return nil, err
+	}
+	return parsePositions(data)
+}
+
+func parsePositions(data []byte) (map[string]int64, error) {
+	p := Positions{}
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("error decode positions: %v", err)
+	}
+	ret := make(map[string]int64, len(p.Positions))
+	for f, v := range p.Positions {
+		pos, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			// Cursors of other targets, e.g. journal
+			continue
+		}
+		ret[f] = pos
+	}
+	return ret, nil
+}
@@ -0,0 +1,96 @@
+package promtail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+func TestTargets(t *testing.T) {
+	c := &promtailConfigurer{
+		labels:  map[string]struct{}{"kubernetes.pod_name": {}},
+		dropped: make(map[string]struct{}),
+		logger:  logp.NewLogger("test"),
+	}
+	ev := &configurer.ContainerAddEvent{
+		LogConfigs: []*configurer.LogConfig{
+			{
+				Name:    "access",
+				LogFile: "/opt/tomcat/access.log",
+				Tags:    map[string]string{"kubernetes.pod_name": "tomcat", "filePath": "/opt/tomcat/access.log"},
+			},
+		},
+	}
+	targets := c.targets(ev)
+	if len(targets) != 1 {
+		t.Fatalf("expect 1 target, got %v", targets)
+	}
+	labels := targets[0].Labels
+	if labels["__path__"] != "/opt/tomcat/access.log" || labels["kubernetes_pod_name"] != "tomcat" || len(labels) != 2 {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+	if _, ok := c.dropped["filePath"]; !ok || len(c.dropped) != 1 {
+		t.Errorf("expect filePath logged as dropped, got %v", c.dropped)
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	positions, err := parsePositions([]byte("positions:\n  /var/log/a.log: \"100\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if positions["/var/log/a.log"] != 100 {
+		t.Errorf("unexpected positions: %v", positions)
+	}
+}
+
+func TestDestroyUnknown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(logFile, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New("/", filepath.Join(dir, "sd"), filepath.Join(dir, "positions.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	con := container.Container{ID: "1"}
+	ev := &configurer.ContainerAddEvent{
+		Container:  con,
+		LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: logFile}},
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarted, and the container is destroyed while log-pilot is down
+	c, err = New("/", filepath.Join(dir, "sd"), filepath.Join(dir, "positions.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := c.(*promtailConfigurer)
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: con}); err != nil {
+		t.Fatal(err)
+	}
+	added := pc.watchContainer["1"]
+	if len(added.LogConfigs) != 1 || added.LogConfigs[0].LogFile != logFile {
+		t.Fatalf("expect log files recovered, got %v", added.LogConfigs)
+	}
+	if pc.readToEnd(added, map[string]int64{logFile: 2}) {
+		t.Error("expect logs not read to the end")
+	}
+	if !pc.readToEnd(added, map[string]int64{logFile: 6}) {
+		t.Error("expect logs read to the end")
+	}
+}
@@ -0,0 +1,100 @@
+package configurer
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options are shared by all configurers.
+type Options struct {
+	// BaseDir is the directory which mounts host path.
+	BaseDir string
+	// Template is the default template path of configurers which render
+	// templates.
+	Template string
+	// Quarantine is nil if runaway loggers are not detected.
+	Quarantine *QuarantineOptions
+	Recorder   EventRecorder
+}
+
+// Factory creates a configurer from its config section.
+type Factory struct {
+	// Config returns a pointer to the config section filled with default
+	// values, it can be decoded from yaml.
+	Config func() interface{}
+	// Flags binds fields of the config section to command line flags, it's
+	// optional.
+	Flags func(fs *flag.FlagSet, cfg interface{})
+	// New creates the configurer, cfg is the section returned by Config.
+	New func(opts *Options, cfg interface{}) (Configurer, error)
+}
+
+var (
+	factories   = make(map[string]Factory)
+	factoryLock sync.Mutex
+)
+
+// Register makes a configurer available by name, it's usually called in
+// init of the package which implements the configurer. It panics if the
+// name is registered twice.
+func Register(name string, f Factory) {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+	if f.Config == nil || f.New == nil {
+		panic("configurer: Config and New of factory " + name + " are required")
+	}
+	if _, dup := factories[name]; dup {
+		panic("configurer: Register called twice for " + name)
+	}
+	factories[name] = f
+}
+
+// Lookup returns the factory registered by name.
+func Lookup(name string) (Factory, bool) {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+	f, ok := factories[name]
+	return f, ok
+}
+
+// Names returns sorted names of registered configurers.
+func Names() []string {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StringList is a flag.Value of comma separated strings.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the list with comma separated items of s.
+func (l *StringList) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// New creates the configurer registered by name.
+func New(name string, opts *Options, cfg interface{}) (Configurer, error) {
+	f, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown configurer %q, available: %s", name, strings.Join(Names(), ", "))
+	}
+	return f.New(opts, cfg)
+}
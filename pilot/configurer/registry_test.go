@@ -0,0 +1,50 @@
+package configurer
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	type config struct{ Dir string }
+	Register("test", Factory{
+		Config: func() interface{} { return &config{Dir: "/default"} },
+		Flags: func(fs *flag.FlagSet, cfg interface{}) {
+			fs.StringVar(&cfg.(*config).Dir, "test.dir", cfg.(*config).Dir, "")
+		},
+		New: func(opts *Options, cfg interface{}) (Configurer, error) {
+			if cfg.(*config).Dir != "/data" {
+				t.Errorf("expect dir from flag, got %s", cfg.(*config).Dir)
+			}
+			return nil, nil
+		},
+	})
+
+	f, ok := Lookup("test")
+	if !ok {
+		t.Fatal("expect test registered")
+	}
+	cfg := f.Config()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.Flags(fs, cfg)
+	if err := fs.Parse([]string{"-test.dir=/data"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New("test", &Options{}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New("unknown", &Options{}, nil); err == nil {
+		t.Error("expect error for unknown configurer")
+	}
+}
+
+func TestStringList(t *testing.T) {
+	var l StringList
+	if err := l.Set(" a, b,,c "); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l, StringList{"a", "b", "c"}) {
+		t.Errorf("unexpected list: %v", l)
+	}
+}
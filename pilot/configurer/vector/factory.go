@@ -0,0 +1,35 @@
+package vector
+
+import (
+	"flag"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+// Config is the config section of vector configurer.
+type Config struct {
+	// Template defaults to configurer.Options.Template.
+	Template  string `yaml:"template"`
+	ConfigDir string `yaml:"configDir"`
+	DataDir   string `yaml:"dataDir"`
+}
+
+func init() {
+	configurer.Register("vector", configurer.Factory{
+		Config: func() interface{} { return &Config{} },
+		Flags: func(fs *flag.FlagSet, cfg interface{}) {
+			c := cfg.(*Config)
+			fs.StringVar(&c.Template, "path.vector-template", "", "Template file path for vector, defaults to path.template")
+			fs.StringVar(&c.ConfigDir, "path.vector-config-dir", "", "Config directory watched by Vector")
+			fs.StringVar(&c.DataDir, "path.vector-data-dir", "", "Vector data directory")
+		},
+		New: func(opts *configurer.Options, cfg interface{}) (configurer.Configurer, error) {
+			c := cfg.(*Config)
+			tmpl := c.Template
+			if tmpl == "" {
+				tmpl = opts.Template
+			}
+			return New(opts.BaseDir, tmpl, c.ConfigDir, c.DataDir)
+		},
+	})
+}
@@ -0,0 +1,362 @@
+package vector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Checkpoint is copied from vector file source checkpointer, files are
+// fingerprinted by device and inode.
+type Checkpoint struct {
+	Fingerprint struct {
+		DevInode []uint64 `json:"dev_inode"`
+	} `json:"fingerprint"`
+	Position int64 `json:"position"`
+}
+
+// Checkpoints is the content of <data_dir>/<source_id>/checkpoints.json.
+type Checkpoints struct {
+	Version     string       `json:"version"`
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+type vectorConfigurer struct {
+	name string
+	base string
+	// configDir is watched by vector, started with:
+	//   vector --watch-config --config-dir <configDir>
+	configDir string
+	// dataDir is vector data_dir, checkpoints are stored here.
+	dataDir       string
+	tmpl          *template.Template
+	closeCh       chan bool
+	watchDuration time.Duration
+	// containers are running containers, keyed by container ID.
+	containers map[string]*configurer.ContainerAddEvent
+	// watchContainer are destroyed containers whose logs may be not read yet.
+	watchContainer map[string]*configurer.ContainerAddEvent
+	logger         log.Logger
+	lock           sync.Mutex
+}
+
+// New creates a new vector configurer.
+func New(baseDir, configTemplateFile, configDir, dataDir string) (configurer.Configurer, error) {
+	t, err := template.ParseFiles(configTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parse log template: %v", err)
+	}
+
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, err
+	}
+
+	logger := log.NewLogger("configurer")
+	return &vectorConfigurer{
+		logger:         logger,
+		name:           "vector",
+		base:           baseDir,
+		configDir:      configDir,
+		dataDir:        dataDir,
+		tmpl:           t,
+		closeCh:        make(chan bool),
+		watchDuration:  60 * time.Second,
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		watchContainer: make(map[string]*configurer.ContainerAddEvent),
+	}, nil
+}
+
+func (c *vectorConfigurer) Name() string {
+	return c.name
+}
+
+func (c *vectorConfigurer) Start() error {
+	go func() {
+		if err := c.watch(); err != nil {
+			c.logger.Errorf("error watch: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (c *vectorConfigurer) Stop() {
+	close(c.closeCh)
+}
+
+// BootstrapCheck removes unknown files and old version files, and returns
+// all the config files.
+func (c *vectorConfigurer) BootstrapCheck() (map[string]*configurer.InputConfigFile, error) {
+	if _, err := configurer.RemoveTempFiles(c.configDir); err != nil {
+		return nil, fmt.Errorf("error remove temporary files: %v", err)
+	}
+	files, err := ioutil.ReadDir(c.configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	toRemove := []string{}
+	ret := make(map[string]*configurer.InputConfigFile)
+	for i := range files {
+		base := files[i].Name()
+		inputConfig, err := configurer.LoadInput(filepath.Join(c.configDir, base))
+		if err != nil {
+			log.Warnf("unable to load input config %s: %v", base, err)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		if inputConfig.Version != currentInputConfigVersion {
+			log.Infof("old version: %s", base)
+			toRemove = append(toRemove, base)
+			continue
+		}
+		ret[inputConfig.ContainerID] = inputConfig
+	}
+
+	for _, base := range toRemove {
+		if err := os.Remove(filepath.Join(c.configDir, base)); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// <hash of container ID>.yaml, see configurer.InputFileName.
+func (c *vectorConfigurer) getContainerConfigPath(con *container.Container) string {
+	return filepath.Join(c.configDir, configurer.InputFileName(con.ID, ".yaml"))
+}
+
+var invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// componentID returns id of a log source, its vector file source is named
+// src_<id>, and remap transform is named logpilot_<id>. Sinks can use all
+// the transforms by:
+//
+//	inputs: ["logpilot_*"]
+func componentID(con *container.Container, cfg *configurer.LogConfig) string {
+	return invalidIDChars.ReplaceAllString(con.ID+"_"+cfg.Name, "_")
+}
+
+func sourceID(con *container.Container, cfg *configurer.LogConfig) string {
+	return "src_" + componentID(con, cfg)
+}
+
+func transformID(con *container.Container, cfg *configurer.LogConfig) string {
+	return "logpilot_" + componentID(con, cfg)
+}
+
+func (c *vectorConfigurer) OnAdd(ev *configurer.ContainerAddEvent) error {
+	if err := configurer.RejectRedactions(c.Name(), ev); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	content, err := c.render(ev)
+	if err != nil {
+		return fmt.Errorf("error render config file: %v", err)
+	}
+
+	confPath := c.getContainerConfigPath(&ev.Container)
+	data, err := configurer.WithHeader(&ev.Container, currentInputConfigVersion, []byte(content))
+	if err != nil {
+		return err
+	}
+	if _, err := configurer.WriteFileAtomic(confPath, data, 0644); err != nil {
+		return fmt.Errorf("error write config file: %v", err)
+	}
+	c.containers[ev.Container.ID] = ev
+
+	c.logger.Info("Configuration updated successfully for container", ev.Container.ID)
+	return nil
+}
+
+type source struct {
+	*configurer.LogConfig
+	SourceID    string
+	TransformID string
+}
+
+func (c *vectorConfigurer) render(ev *configurer.ContainerAddEvent) (string, error) {
+	var sources []source
+	for _, cfg := range ev.LogConfigs {
+		sources = append(sources, source{
+			LogConfig:   cfg,
+			SourceID:    sourceID(&ev.Container, cfg),
+			TransformID: transformID(&ev.Container, cfg),
+		})
+	}
+
+	var buf bytes.Buffer
+	context := map[string]interface{}{
+		"containerId": ev.Container.ID,
+		"dataDir":     c.dataDir,
+		"sources":     sources,
+	}
+	if err := c.tmpl.Execute(&buf, context); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *vectorConfigurer) OnDestroy(ev *configurer.ContainerDestroyEvent) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	added, ok := c.containers[ev.Container.ID]
+	if !ok {
+		// Logs added before restart are unknown, they are recovered from the
+		// config file, so checkpoints can be looked up by source id.
+		var err error
+		added, err = c.loadInput(&ev.Container)
+		if err != nil {
+			c.logger.Warnf("error load log config of %s, remove it in next scan: %v", ev.Container.ID, err)
+			added = &configurer.ContainerAddEvent{Container: ev.Container}
+		}
+	}
+	delete(c.containers, ev.Container.ID)
+	c.watchContainer[ev.Container.ID] = added
+	return nil
+}
+
+// loadInput recovers log configs of the container from its config file,
+// only names and log files are recovered.
+func (c *vectorConfigurer) loadInput(con *container.Container) (*configurer.ContainerAddEvent, error) {
+	data, err := ioutil.ReadFile(c.getContainerConfigPath(con))
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Sources map[string]struct {
+			Include []string `yaml:"include"`
+		} `yaml:"sources"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error decode config file: %v", err)
+	}
+
+	ret := &configurer.ContainerAddEvent{Container: *con}
+	prefix := sourceID(con, &configurer.LogConfig{})
+	for id, src := range cfg.Sources {
+		if !strings.HasPrefix(id, prefix) || len(src.Include) != 1 {
+			return nil, fmt.Errorf("unexpected source %s", id)
+		}
+		ret.LogConfigs = append(ret.LogConfigs, &configurer.LogConfig{
+			Name:    strings.TrimPrefix(id, prefix),
+			LogFile: src.Include[0],
+		})
+	}
+	return ret, nil
+}
+
+func (c *vectorConfigurer) watch() error {
+	c.logger.Infof("%s watcher start", c.Name())
+	for {
+		select {
+		case <-c.closeCh:
+			c.logger.Infof("%s watcher stop", c.Name())
+			return nil
+		case <-time.After(c.watchDuration):
+			c.logger.Infof("%s watcher scan", c.Name())
+
+			startTs := time.Now()
+			c.scan()
+			c.logger.Debugf("cost %v to complete scan", time.Since(startTs))
+		}
+	}
+}
+
+// scan gc for config files
+func (c *vectorConfigurer) scan() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for id, ev := range c.watchContainer {
+		confPath := c.getContainerConfigPath(&ev.Container)
+		if _, err := os.Stat(confPath); err != nil && os.IsNotExist(err) {
+			c.logger.Infof("log config of %s has been removed and ignore", id)
+			delete(c.watchContainer, id)
+			continue
+		}
+		done, err := c.readToEnd(ev)
+		if err != nil {
+			c.logger.Warnf("error check checkpoints of %s: %v", id, err)
+			continue
+		}
+		if !done {
+			c.logger.Debugf("logs of %s are not read to the end, will try to remove it in next scan", id)
+			continue
+		}
+		c.logger.Infof("try to remove log config of %s", id)
+		if err := os.Remove(confPath); err != nil {
+			c.logger.Errorf("remove log config of %s fail: %v", id, err)
+		} else {
+			delete(c.watchContainer, id)
+		}
+	}
+}
+
+// readToEnd checks whether all files of the container are read to the end
+// according to vector checkpoints. Files which don't exist any more are
+// considered done.
+func (c *vectorConfigurer) readToEnd(ev *configurer.ContainerAddEvent) (bool, error) {
+	for _, cfg := range ev.LogConfigs {
+		checkpoints, err := c.loadCheckpoints(sourceID(&ev.Container, cfg))
+		if err != nil {
+			return false, err
+		}
+		positions := make(map[[2]uint64]int64)
+		for _, cp := range checkpoints.Checkpoints {
+			if len(cp.Fingerprint.DevInode) == 2 {
+				positions[[2]uint64{cp.Fingerprint.DevInode[0], cp.Fingerprint.DevInode[1]}] = cp.Position
+			}
+		}
+
+		files, err := filepath.Glob(cfg.LogFile)
+		if err != nil {
+			return false, err
+		}
+		for _, f := range files {
+			var st syscall.Stat_t
+			if err := syscall.Stat(f, &st); err != nil {
+				continue
+			}
+			pos, ok := positions[[2]uint64{uint64(st.Dev), uint64(st.Ino)}]
+			if !ok || pos < st.Size {
+				c.logger.Debugf("%s is read to %d of %d", f, pos, st.Size)
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+func (c *vectorConfigurer) loadCheckpoints(id string) (*Checkpoints, error) {
+	ret := &Checkpoints{}
+	data, err := ioutil.ReadFile(filepath.Join(c.dataDir, id, "checkpoints.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ret); err != nil {
+		return nil, fmt.Errorf("error decode checkpoints: %v", err)
+	}
+	return ret, nil
+}
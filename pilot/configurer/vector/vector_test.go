@@ -0,0 +1,174 @@
+package vector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"text/template"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/container"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+func TestRender(t *testing.T) {
+	tmpl, err := template.ParseFiles("vector.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &vectorConfigurer{
+		tmpl:    tmpl,
+		dataDir: "/var/lib/vector",
+	}
+	ev := configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{
+			&configurer.LogConfig{
+				Name:    "access",
+				LogFile: "/opt/tomcat/access.log",
+				Format:  configurer.LogFormatPlain,
+				Tags:    map[string]string{"foo": "bar"},
+			},
+		},
+	}
+	content, err := c.render(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{"src_1_access:", "logpilot_1_access:", `."foo" = "bar"`} {
+		if !strings.Contains(content, expect) {
+			t.Errorf("expect %q in rendered config:\n%s", expect, content)
+		}
+	}
+}
+
+func TestRenderStdout(t *testing.T) {
+	tmpl, err := template.ParseFiles("vector.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &vectorConfigurer{tmpl: tmpl}
+	ev := configurer.ContainerAddEvent{
+		Container: container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{
+			{Name: "stdout", LogFile: "/var/lib/docker/containers/1/1-json.log", Stdout: true, Format: configurer.LogFormatJSON},
+		},
+	}
+	content, err := c.render(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the payload is decoded inside the envelope branch only
+	if strings.Count(content, "parse_json(.message)") != 2 || strings.Count(content, "payload, err") != 1 {
+		t.Errorf("expect one remap of stdout:\n%s", content)
+	}
+}
+
+func TestDestroyUnknown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpl, err := template.ParseFiles("vector.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile := filepath.Join(dir, "app.log")
+	con := container.Container{ID: "1", Namespace: "default", Pod: "app", Name: "app"}
+	ev := &configurer.ContainerAddEvent{
+		Container:  con,
+		LogConfigs: []*configurer.LogConfig{{Name: "app-log", LogFile: logFile}},
+	}
+	c := &vectorConfigurer{
+		tmpl:           tmpl,
+		configDir:      dir,
+		dataDir:        dir,
+		logger:         logp.NewLogger("test"),
+		containers:     make(map[string]*configurer.ContainerAddEvent),
+		watchContainer: make(map[string]*configurer.ContainerAddEvent),
+	}
+	if err := c.OnAdd(ev); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarted, the container is unknown
+	c.containers = make(map[string]*configurer.ContainerAddEvent)
+	if err := c.OnDestroy(&configurer.ContainerDestroyEvent{Container: con}); err != nil {
+		t.Fatal(err)
+	}
+	added := c.watchContainer["1"]
+	if len(added.LogConfigs) != 1 || added.LogConfigs[0].LogFile != logFile {
+		t.Fatalf("expect log configs recovered, got %+v", added)
+	}
+	if sourceID(&con, added.LogConfigs[0]) != sourceID(&con, ev.LogConfigs[0]) {
+		t.Errorf("expect the same source id, got %s", sourceID(&con, added.LogConfigs[0]))
+	}
+
+	// Logs are not read, so the config is kept
+	if err := ioutil.WriteFile(logFile, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.scan()
+	if _, err := os.Stat(c.getContainerConfigPath(&con)); err != nil {
+		t.Errorf("expect config kept: %v", err)
+	}
+}
+
+func TestReadToEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(logFile, []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(logFile, &st); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &vectorConfigurer{
+		dataDir: dir,
+		logger:  logp.NewLogger("test"),
+	}
+	ev := &configurer.ContainerAddEvent{
+		Container:  container.Container{ID: "1"},
+		LogConfigs: []*configurer.LogConfig{{Name: "app", LogFile: logFile}},
+	}
+	writeCheckpoint := func(pos int64) {
+		cp := Checkpoint{Position: pos}
+		cp.Fingerprint.DevInode = []uint64{uint64(st.Dev), uint64(st.Ino)}
+		data, _ := json.Marshal(Checkpoints{Version: "1", Checkpoints: []Checkpoint{cp}})
+		cpDir := filepath.Join(dir, sourceID(&ev.Container, ev.LogConfigs[0]))
+		if err := os.MkdirAll(cpDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(cpDir, "checkpoints.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if done, err := c.readToEnd(ev); err != nil || done {
+		t.Errorf("expect not done without checkpoint, got %v, %v", done, err)
+	}
+	writeCheckpoint(3)
+	if done, err := c.readToEnd(ev); err != nil || done {
+		t.Errorf("expect not done, got %v, %v", done, err)
+	}
+	writeCheckpoint(6)
+	if done, err := c.readToEnd(ev); err != nil || !done {
+		t.Errorf("expect done, got %v, %v", done, err)
+	}
+}
@@ -10,15 +10,18 @@ import (
 	"time"
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
+	"github.com/caicloud/log-pilot/pilot/configurer/fanout"
 	"github.com/caicloud/log-pilot/pilot/container"
 	"github.com/caicloud/log-pilot/pilot/kube"
 	"github.com/caicloud/log-pilot/pilot/log"
+	"github.com/caicloud/log-pilot/pilot/redaction"
+	"github.com/caicloud/log-pilot/pilot/routing"
+	"github.com/caicloud/log-pilot/pilot/throttle"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/elastic/beats/libbeat/logp"
 )
 
 // Discovery watchs container start and destory events,
@@ -26,6 +29,19 @@ import (
 type Discovery interface {
 	Start() error
 	Stop()
+	// Reload applies settings to running and new containers.
+	Reload(settings Settings) error
+}
+
+// Settings are options of discovery which can be changed at runtime.
+type Settings struct {
+	// NamespaceBlacklist are namespaces to ignore.
+	NamespaceBlacklist []string
+	// NamespaceWhitelist are namespaces to watch, empty means all.
+	NamespaceWhitelist []string
+	// Tags are added to all log configs, e.g. cluster name. Tags of the
+	// container and its release take precedence.
+	Tags map[string]string
 }
 
 // containerInfo saves basic informations for a container
@@ -35,6 +51,8 @@ type containerInfo struct {
 	// Compatible with old interface, which use pod annotation to store
 	// log sources.
 	LegacyLogSources []string
+	// ThrottleOptions are rate_* and sampling options from pod annotations.
+	ThrottleOptions map[string]string
 }
 
 type discovery struct {
@@ -50,10 +68,31 @@ type discovery struct {
 	mutex           sync.Mutex
 	bListNS         map[string]struct{} // blacklisted namespaces
 	wListNS         map[string]struct{} // whitelisted namespaces
+	tags            map[string]string
+	// levelOpts is the default level detection options, nil means disabled.
+	levelOpts *configurer.LevelOptions
+	// redaction contains rules for each namespace, nil means no redaction.
+	redaction *redaction.Rules
+	// routing contains route for each namespace, nil means default output.
+	routing *routing.Rules
+	// throttle contains policy for each namespace, nil means unlimited.
+	throttle *throttle.Rules
+	// reloadCh passes reloads to the watch loop, so they are serialized
+	// with container events.
+	reloadCh chan *reloadRequest
+	// watchDone is closed when the watch loop returns.
+	watchDone chan struct{}
+}
+
+type reloadRequest struct {
+	settings Settings
+	done     chan error
 }
 
 // New creates a new Discovery
-func New(baseDir, logPrefix string, configurer configurer.Configurer, bListNS, wListNS []string) (Discovery, error) {
+func New(baseDir, logPrefix string, configurer configurer.Configurer, settings Settings,
+	levelOpts *configurer.LevelOptions, redaction *redaction.Rules, routing *routing.Rules,
+	throttle *throttle.Rules) (Discovery, error) {
 	if os.Getenv("DOCKER_API_VERSION") == "" {
 		os.Setenv("DOCKER_API_VERSION", "1.23")
 	}
@@ -72,7 +111,7 @@ func New(baseDir, logPrefix string, configurer configurer.Configurer, bListNS, w
 		}
 	}
 
-	logger := logp.NewLogger("discovery")
+	logger := log.NewLogger("discovery")
 	logger.Info("Use log prefix:", logPrefix)
 
 	cache, err := kube.New()
@@ -91,8 +130,15 @@ func New(baseDir, logPrefix string, configurer configurer.Configurer, bListNS, w
 		base:            baseDir,
 		logPrefixes:     prefixes,
 		existContainers: make(map[string]*containerInfo),
-		bListNS:         listToSet(bListNS),
-		wListNS:         listToSet(wListNS),
+		bListNS:         listToSet(settings.NamespaceBlacklist),
+		wListNS:         listToSet(settings.NamespaceWhitelist),
+		tags:            settings.Tags,
+		levelOpts:       levelOpts,
+		redaction:       redaction,
+		routing:         routing,
+		throttle:        throttle,
+		reloadCh:        make(chan *reloadRequest),
+		watchDone:       make(chan struct{}),
 	}, nil
 }
 
@@ -106,13 +152,19 @@ func (d *discovery) Start() error {
 	d.logger.Info("Cache synced")
 
 	if err := d.configurer.Start(); err != nil {
-		return err
+		if !fanout.IsPartial(err) {
+			return err
+		}
+		d.logger.Warnf("Some configurers are not started: %v", err)
 	}
 	d.logger.Info("Configurer started")
 
 	collected, err := d.configurer.BootstrapCheck()
 	if err != nil {
-		return fmt.Errorf("bootstrap check failed: %v", err)
+		if !fanout.IsPartial(err) {
+			return fmt.Errorf("bootstrap check failed: %v", err)
+		}
+		d.logger.Warnf("Bootstrap check of some configurers failed: %v", err)
 	}
 	d.logger.Info("Bootstrap check done")
 
@@ -122,12 +174,26 @@ func (d *discovery) Start() error {
 	}
 	d.logger.Infof("Cost %v to process all events", time.Since(startTs))
 
-	// Remove configuration files if container not exist
-	for ID, info := range collected {
-		if _, exist := d.existContainers[ID]; !exist {
-			if err := os.Remove(info.Path); err != nil {
-				return err
-			}
+	// Containers of the collected files are destroyed while log-pilot is
+	// down, the files are removed by configurers after logs are read.
+	destroyed := make(map[string]bool)
+	for _, info := range collected {
+		if d.exists(info.ContainerID) || destroyed[info.ContainerID] {
+			continue
+		}
+		destroyed[info.ContainerID] = true
+		d.logger.Infof("Container %s is destroyed while log-pilot is down", info.ContainerID)
+		err := d.configurer.OnDestroy(&configurer.ContainerDestroyEvent{
+			Container: container.Container{
+				ID:        info.ContainerID,
+				Name:      info.Container,
+				Namespace: info.Namespace,
+				Pod:       info.Pod,
+				PodID:     info.PodID,
+			},
+		})
+		if err != nil {
+			d.logger.Errorf("error destroy container %s: %v", info.ContainerID, err)
 		}
 	}
 
@@ -139,6 +205,7 @@ func (d *discovery) Start() error {
 }
 
 func (d *discovery) watch() error {
+	defer close(d.watchDone)
 	ctx := d.ctx
 	filter := filters.NewArgs()
 	filter.Add("type", "container")
@@ -167,6 +234,8 @@ func (d *discovery) watch() error {
 			} else {
 				msgs, errs = d.client.Events(ctx, options)
 			}
+		case req := <-d.reloadCh:
+			req.done <- d.reload(req.settings)
 		}
 	}
 }
@@ -208,6 +277,7 @@ func getContainerInfo(cache kube.Cache, containerJSON *types.ContainerJSON) *con
 	if ret.Pod != "" && ret.Namespace != "" {
 		ret.ReleaseMeta = cache.GetReleaseMeta(ret.Namespace, ret.Pod)
 		ret.LegacyLogSources = cache.GetLegacyLogSources(ret.Namespace, ret.Pod, ret.Name)
+		ret.ThrottleOptions = cache.GetThrottleOptions(ret.Namespace, ret.Pod)
 	}
 	return ret
 }
@@ -280,7 +350,11 @@ func (d *discovery) newContainer(containerJSON *types.ContainerJSON) error {
 	}
 
 	if err := d.configurer.OnAdd(ev); err != nil {
-		return fmt.Errorf("error update config: %v", err)
+		// The container is kept if other configurers collect it
+		if !fanout.IsPartial(err) {
+			return fmt.Errorf("error update config: %v", err)
+		}
+		d.logger.Errorf("Some configurers fail to update config of %s: %v", containerJSON.ID, err)
 	}
 
 	d.addContainer(containerJSON.ID, info)
@@ -308,7 +382,56 @@ func (d *discovery) Stop() {
 	d.configurer.Stop()
 }
 
+// Reload applies settings in the watch loop, it waits until containers are
+// processed again.
+func (d *discovery) Reload(settings Settings) error {
+	req := &reloadRequest{settings: settings, done: make(chan error, 1)}
+	select {
+	case d.reloadCh <- req:
+	case <-d.watchDone:
+		return fmt.Errorf("discovery is stopped")
+	}
+	return <-req.done
+}
+
+// reload applies settings, containers are processed again so they are
+// selected and enriched by the new settings.
+func (d *discovery) reload(settings Settings) error {
+	d.mutex.Lock()
+	d.bListNS = listToSet(settings.NamespaceBlacklist)
+	d.wListNS = listToSet(settings.NamespaceWhitelist)
+	d.tags = settings.Tags
+	var dropped []string
+	for id, info := range d.existContainers {
+		if !d.isResponsibleLocked(info.Namespace) {
+			dropped = append(dropped, id)
+		}
+	}
+	d.mutex.Unlock()
+
+	for _, id := range dropped {
+		d.logger.Infof("Container %s is not selected any more", id)
+		if err := d.delContainer(id); err != nil {
+			d.logger.Errorf("error remove container %s: %v", id, err)
+		}
+	}
+	return d.processAllContainers()
+}
+
+// getTags returns tags added to all log configs.
+func (d *discovery) getTags() map[string]string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.tags
+}
+
 func (d *discovery) isResponsible(namespace string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.isResponsibleLocked(namespace)
+}
+
+func (d *discovery) isResponsibleLocked(namespace string) bool {
 	if _, inBList := d.bListNS[namespace]; inBList {
 		return false
 	}
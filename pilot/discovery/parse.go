@@ -4,10 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/caicloud/log-pilot/pilot/configurer"
 	"github.com/caicloud/log-pilot/pilot/log"
+	"github.com/caicloud/log-pilot/pilot/routing"
+	"github.com/caicloud/log-pilot/pilot/throttle"
 	"github.com/docker/docker/api/types"
 )
 
@@ -67,16 +72,50 @@ func (ls logOptionsSet) insert(name, opt, v string) {
 			name:         name,
 			format:       configurer.LogFormatPlain,
 			inputOptions: make(map[string]string),
+			jsonOptions:  make(map[string]string),
+			tsOptions:    make(map[string]string),
+			levelOptions: make(map[string]string),
+			rateOptions:  make(map[string]string),
+		}
+		if name == "stdout" {
+			// Options may be set without the switch, stdout is enabled by default.
+			ls[name].source = "true"
+			ls[name].format = configurer.LogFormatJSON
 		}
 	}
 
 	if opt != "" {
-		if opt == "format" {
-			if v == "json" {
-				ls[name].format = configurer.LogFormatJSON
-			} else {
-				ls[name].format = configurer.LogFormatPlain
+		switch opt {
+		case "format":
+			format, ok := configurer.ParseLogFormat(v)
+			if !ok {
+				log.Warnf("unknown log format %q of %s, fallback to %s", v, name, format)
 			}
+			ls[name].format = format
+			return
+		case "format_pattern":
+			ls[name].pattern = v
+			return
+		case "json_decode", "json_target", "json_overwrite_keys", "json_add_error_key":
+			ls[name].jsonOptions[opt] = v
+			return
+		case "timestamp_field", "timestamp_pattern", "timestamp_layouts", "timezone":
+			ls[name].tsOptions[opt] = v
+			return
+		case "level_detect", "level_min":
+			ls[name].levelOptions[opt] = v
+			return
+		case "output_index":
+			ls[name].route.Index = v
+			return
+		case "output_topic":
+			ls[name].route.Topic = v
+			return
+		case "output_pipeline":
+			ls[name].route.Pipeline = v
+			return
+		case "rate_lines", "rate_lines_burst", "rate_bytes", "rate_bytes_burst", "sampling":
+			ls[name].rateOptions[opt] = v
 			return
 		}
 
@@ -92,6 +131,19 @@ type logOptions struct {
 	name   string
 	source string
 	format configurer.LogFormat
+	// pattern is used by regex and dissect format.
+	pattern string
+	// jsonOptions defines how json payload is decoded, see parseJSONDecodeOptions.
+	jsonOptions map[string]string
+	// tsOptions defines how timestamp is extracted, see parseTimestampOptions.
+	tsOptions map[string]string
+	// levelOptions defines how level is detected, see parseLevelOptions.
+	levelOptions map[string]string
+	// route overrides the namespace route.
+	route routing.Route
+	// rateOptions overrides the namespace throttle policy, options of pod
+	// annotations are merged in, see parseThrottlePolicy.
+	rateOptions map[string]string
 
 	// inputOptions defines log collecting options.
 	inputOptions map[string]string
@@ -156,6 +208,20 @@ func parseLogConfigs(d *discovery, info *containerInfo, containerJSON *types.Con
 		for k, v := range info.ReleaseMeta {
 			opts.tags[k] = v
 		}
+		for k, v := range d.getTags() {
+			if _, ok := opts.tags[k]; !ok {
+				opts.tags[k] = v
+			}
+		}
+		// Pod annotations are overridden by env of the container.
+		if opts.rateOptions == nil {
+			opts.rateOptions = make(map[string]string)
+		}
+		for k, v := range info.ThrottleOptions {
+			if _, ok := opts.rateOptions[k]; !ok {
+				opts.rateOptions[k] = v
+			}
+		}
 		cfg, err := parseLogConfig(d, d.base, containerJSON, opts, mountsMap)
 		if err != nil {
 			log.Errorf("error parse log source %s(image %s): %v", opts.source, containerJSON.Image, err)
@@ -185,18 +251,257 @@ func parseLogConfig(d *discovery, base string, containerJSON *types.ContainerJSO
 		}
 	}
 
+	if err := validateFormat(opts.format, opts.pattern); err != nil {
+		return nil, err
+	}
+	jsonDecode, err := parseJSONDecodeOptions(opts.jsonOptions)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := parseTimestampOptions(opts.tsOptions)
+	if err != nil {
+		return nil, err
+	}
+	level, err := parseLevelOptions(opts.levelOptions, d.levelOpts)
+	if err != nil {
+		return nil, err
+	}
+	namespace := containerJSON.Config.Labels[labelPodNamespace]
+	route := d.routing.For(namespace).Merge(opts.route)
+	if err := route.Validate(); err != nil {
+		return nil, err
+	}
+	policy, err := parseThrottlePolicy(opts.rateOptions)
+	if err != nil {
+		return nil, err
+	}
+	policy = d.throttle.For(namespace).Merge(policy)
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	throttleOpts := policy.Options()
+	if throttleOpts != nil && level == nil && requireLevel(throttleOpts) {
+		level = &configurer.LevelOptions{}
+	}
+
 	ret := &configurer.LogConfig{
-		Name:    opts.name,
-		Format:  opts.format,
-		LogFile: filepath.Join(base, hostPath),
-		InOpts:  opts.inputOptions,
-		Tags:    opts.tags,
-		Stdout:  isStdout,
+		Name:       opts.name,
+		Format:     opts.format,
+		LogFile:    filepath.Join(base, hostPath),
+		InOpts:     opts.inputOptions,
+		OutOpts:    route.OutOpts(),
+		Tags:       opts.tags,
+		Stdout:     isStdout,
+		Pattern:    opts.pattern,
+		JSONDecode: jsonDecode,
+		Timestamp:  timestamp,
+		Level:      level,
+		Redactions: d.redaction.For(namespace),
+		Throttle:   throttleOpts,
+	}
+
+	return ret, nil
+}
+
+// validateFormat checks user supplied pattern for regex and dissect format.
+func validateFormat(format configurer.LogFormat, pattern string) error {
+	switch format {
+	case configurer.LogFormatRegex:
+		if pattern == "" {
+			return fmt.Errorf("format_pattern is required by %s format", format)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid format_pattern: %v", err)
+		}
+		named := false
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				named = true
+				break
+			}
+		}
+		if !named {
+			return fmt.Errorf("format_pattern %q has no named group", pattern)
+		}
+	case configurer.LogFormatDissect:
+		if pattern == "" {
+			return fmt.Errorf("format_pattern is required by %s format", format)
+		}
+		if !strings.Contains(pattern, "%{") {
+			return fmt.Errorf("format_pattern %q has no dissect key", pattern)
+		}
+	}
+	return nil
+}
+
+// parseJSONDecodeOptions parses options like:
+// <prefix>_log_stdout_json_decode=true
+// <prefix>_log_stdout_json_target=app
+// <prefix>_log_stdout_json_overwrite_keys=true
+// <prefix>_log_stdout_json_add_error_key=true
+// It returns nil if json_decode is not enabled.
+func parseJSONDecodeOptions(opts map[string]string) (*configurer.JSONDecodeOptions, error) {
+	parseBool := func(key string) (bool, error) {
+		v, ok := opts[key]
+		if !ok || v == "" {
+			return false, nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		return b, nil
+	}
+
+	enabled, err := parseBool("json_decode")
+	if err != nil || !enabled {
+		return nil, err
+	}
+	overwrite, err := parseBool("json_overwrite_keys")
+	if err != nil {
+		return nil, err
+	}
+	addErrorKey, err := parseBool("json_add_error_key")
+	if err != nil {
+		return nil, err
+	}
+	return &configurer.JSONDecodeOptions{
+		Target:        opts["json_target"],
+		OverwriteKeys: overwrite,
+		AddErrorKey:   addErrorKey,
+	}, nil
+}
+
+// parseTimestampOptions parses options like:
+// <prefix>_log_<name>_timestamp_field=nginx.time_local
+// <prefix>_log_<name>_timestamp_pattern=^\[([^\]]+)\]
+// <prefix>_log_<name>_timestamp_layouts=02/Jan/2006:15:04:05 -0700|2006-01-02 15:04:05
+// <prefix>_log_<name>_timezone=Asia/Shanghai
+// It returns nil if neither timestamp_field nor timestamp_pattern is set.
+func parseTimestampOptions(opts map[string]string) (*configurer.TimestampOptions, error) {
+	ret := &configurer.TimestampOptions{
+		Field:    opts["timestamp_field"],
+		Pattern:  opts["timestamp_pattern"],
+		Timezone: opts["timezone"],
+	}
+	if ret.Field == "" && ret.Pattern == "" {
+		return nil, nil
+	}
+	if ret.Field != "" && ret.Pattern != "" {
+		return nil, fmt.Errorf("timestamp_field and timestamp_pattern can not be set at the same time")
+	}
+	if ret.Pattern != "" {
+		re, err := regexp.Compile(ret.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp_pattern: %v", err)
+		}
+		if re.NumSubexp() == 0 {
+			return nil, fmt.Errorf("timestamp_pattern %q has no capture group", ret.Pattern)
+		}
+	}
+	for _, layout := range strings.Split(opts["timestamp_layouts"], "|") {
+		if layout = strings.TrimSpace(layout); layout != "" {
+			ret.Layouts = append(ret.Layouts, layout)
+		}
+	}
+	if len(ret.Layouts) == 0 {
+		return nil, fmt.Errorf("timestamp_layouts is required")
+	}
+	if ret.Timezone != "" && !tzOffset.MatchString(ret.Timezone) {
+		if _, err := time.LoadLocation(ret.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %v", err)
+		}
+	}
+	return ret, nil
+}
+
+// tzOffset matches timezone like +08:00, -0700.
+var tzOffset = regexp.MustCompile(`^[+-]\d{2}:?\d{2}$`)
+
+// parseLevelOptions parses options like:
+// <prefix>_log_<name>_level_detect=true
+// <prefix>_log_<name>_level_min=warn
+// Options not set fallback to the global ones. It returns nil if level
+// detection is disabled.
+func parseLevelOptions(opts map[string]string, global *configurer.LevelOptions) (*configurer.LevelOptions, error) {
+	enabled := global != nil
+	min := ""
+	if global != nil {
+		min = global.Min
+	}
+
+	if v, ok := opts["level_detect"]; ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level_detect: %v", err)
+		}
+		enabled = b
+	}
+	if v, ok := opts["level_min"]; ok {
+		if v != "" && !configurer.ValidLogLevel(v) {
+			return nil, fmt.Errorf("invalid level_min %q, expect one of %v", v, configurer.LogLevels)
+		}
+		min = v
+		// Level is required to drop lines.
+		if _, set := opts["level_detect"]; !set && v != "" {
+			enabled = true
+		}
 	}
 
+	if !enabled {
+		return nil, nil
+	}
+	return &configurer.LevelOptions{Min: min}, nil
+}
+
+// parseThrottlePolicy parses options like:
+// <prefix>_log_<name>_rate_lines=100
+// <prefix>_log_<name>_rate_lines_burst=200
+// <prefix>_log_<name>_rate_bytes=1048576
+// <prefix>_log_<name>_rate_bytes_burst=2097152
+// <prefix>_log_<name>_sampling=debug:0.1,*:0.5
+// The same options can be set for all logs of a pod by annotations like
+// logging.caicloud.io/rate_lines, env of the container takes precedence.
+func parseThrottlePolicy(opts map[string]string) (throttle.Policy, error) {
+	ret := throttle.Policy{}
+	ints := map[string]*int{
+		"rate_lines":       &ret.LinesPerSecond,
+		"rate_lines_burst": &ret.LinesBurst,
+		"rate_bytes":       &ret.BytesPerSecond,
+		"rate_bytes_burst": &ret.BytesBurst,
+	}
+	for key, p := range ints {
+		v, ok := opts[key]
+		if !ok || v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return ret, fmt.Errorf("invalid %s: %v", key, err)
+		}
+		*p = n
+	}
+	if v := opts["sampling"]; v != "" {
+		sampling, err := throttle.ParseSampling(v)
+		if err != nil {
+			return ret, err
+		}
+		ret.Sampling = sampling
+	}
 	return ret, nil
 }
 
+// requireLevel reports whether the sampling depends on log level.
+func requireLevel(opts *configurer.ThrottleOptions) bool {
+	for level := range opts.Sampling {
+		if level != "*" {
+			return true
+		}
+	}
+	return false
+}
+
 /**
 场景：
 1. 容器一个路径，中间有多级目录对应宿主机不同的目录
@@ -236,7 +541,18 @@ func getMountMap(containerJSON *types.ContainerJSON) map[string]types.MountPoint
 
 // definitions of multiline_pattern, include_lines, exclude_lines can be found in
 // https://github.com/elastic/beats/blob/v6.4.2/filebeat/filebeat.reference.yml
-var validOptions = []string{"multiline_pattern", "include_lines", "exclude_lines"}
+// format and format_pattern define how log lines are parsed, see configurer.LogFormat.
+// json_* define how json payload is decoded, see parseJSONDecodeOptions.
+// timestamp_* and timezone define how timestamp is extracted, see parseTimestampOptions.
+// level_* define how log level is detected, see parseLevelOptions.
+// output_* define where the logs are sent to, see routing.Route.
+// rate_* and sampling define throttle policy, see parseThrottlePolicy.
+var validOptions = []string{"multiline_pattern", "include_lines", "exclude_lines", "format_pattern", "format",
+	"json_decode", "json_target", "json_overwrite_keys", "json_add_error_key",
+	"timestamp_field", "timestamp_pattern", "timestamp_layouts", "timezone",
+	"level_detect", "level_min",
+	"output_index", "output_topic", "output_pipeline",
+	"rate_lines_burst", "rate_lines", "rate_bytes_burst", "rate_bytes", "sampling"}
 
 func parseLogsEnv(prefixes []string, key string) (name, opt string) {
 	var (
@@ -37,3 +37,53 @@ func TestParseLogEnv(t *testing.T) {
 		}
 	}
 }
+
+func TestParseJSONDecodeOptions(t *testing.T) {
+	opts, err := parseJSONDecodeOptions(map[string]string{"json_target": "app"})
+	if err != nil || opts != nil {
+		t.Errorf("expect disabled, got %v, %v", opts, err)
+	}
+
+	opts, err = parseJSONDecodeOptions(map[string]string{
+		"json_decode":         "true",
+		"json_target":         "app",
+		"json_overwrite_keys": "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Target != "app" || !opts.OverwriteKeys || opts.AddErrorKey {
+		t.Errorf("unexpected options: %#v", opts)
+	}
+
+	if _, err := parseJSONDecodeOptions(map[string]string{"json_decode": "yes"}); err == nil {
+		t.Error("expect error for invalid bool")
+	}
+}
+
+func TestParseTimestampOptions(t *testing.T) {
+	cases := []struct {
+		opts map[string]string
+		nil  bool
+		err  bool
+	}{
+		{map[string]string{"timezone": "UTC"}, true, false},
+		{map[string]string{"timestamp_field": "time", "timestamp_layouts": "2006-01-02 15:04:05|2006-01-02", "timezone": "+08:00"}, false, false},
+		{map[string]string{"timestamp_pattern": `^\[([^\]]+)\]`, "timestamp_layouts": "02/Jan/2006:15:04:05 -0700"}, false, false},
+		{map[string]string{"timestamp_field": "time"}, false, true},
+		{map[string]string{"timestamp_pattern": `^\[.+\]`, "timestamp_layouts": "2006"}, false, true},
+		{map[string]string{"timestamp_field": "time", "timestamp_pattern": "(.*)", "timestamp_layouts": "2006"}, false, true},
+		{map[string]string{"timestamp_field": "time", "timestamp_layouts": "2006", "timezone": "Nowhere/Foo"}, false, true},
+	}
+
+	for i, cas := range cases {
+		opts, err := parseTimestampOptions(cas.opts)
+		if (err != nil) != cas.err {
+			t.Errorf("case %d: expect error %v, got %v", i, cas.err, err)
+			continue
+		}
+		if !cas.err && (opts == nil) != cas.nil {
+			t.Errorf("case %d: expect nil %v, got %#v", i, cas.nil, opts)
+		}
+	}
+}
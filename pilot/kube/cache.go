@@ -20,6 +20,8 @@ type Cache interface {
 	Start(stopCh <-chan struct{}) error
 	GetReleaseMeta(namespace, pod string) map[string]string
 	GetLegacyLogSources(namespace, pod, container string) []string
+	// GetThrottleOptions returns throttle options from pod annotations.
+	GetThrottleOptions(namespace, pod string) map[string]string
 }
 
 // New create a new Cache
@@ -110,6 +112,15 @@ func (c *kubeCache) GetLegacyLogSources(namespace, podName, containerName string
 	return sources
 }
 
+func (c *kubeCache) GetThrottleOptions(namespace, name string) map[string]string {
+	pod, err := c.pc.Get(namespace, name)
+	if err != nil {
+		log.Errorf("error get pod from cache: %v", err)
+		return nil
+	}
+	return extractThrottleOptions(pod)
+}
+
 type podsCache struct {
 	lwCache *ListWatchCache
 	kc      kubernetes.Interface
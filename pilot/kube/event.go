@@ -0,0 +1,89 @@
+package kube
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/caicloud/log-pilot/pilot/log"
+
+	"github.com/caicloud/clientset/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+// eventQueueSize is how many events can wait to be created, events are
+// dropped if the queue is full.
+const eventQueueSize = 256
+
+// EventRecorder records events of pods. Events are created by a goroutine,
+// so callers are not blocked by a slow apiserver.
+type EventRecorder struct {
+	component string
+	host      string
+	kc        kubernetes.Interface
+	queue     chan *corev1.Event
+}
+
+// NewEventRecorder creates a recorder which reports events as component.
+func NewEventRecorder(component string) (*EventRecorder, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	kc, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &EventRecorder{
+		component: component,
+		host:      os.Getenv("NODE_NAME"),
+		kc:        kc,
+		queue:     make(chan *corev1.Event, eventQueueSize),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *EventRecorder) run() {
+	for ev := range r.queue {
+		if _, err := r.kc.CoreV1().Events(ev.Namespace).Create(ev); err != nil {
+			log.Errorf("error create event %s for pod %s/%s: %v", ev.Reason, ev.Namespace, ev.InvolvedObject.Name, err)
+		}
+	}
+}
+
+// Event queues an event for the pod. Errors are logged and ignored.
+func (r *EventRecorder) Event(namespace, pod, podID, eventType, reason, message string) {
+	now := metav1.NewTime(time.Now())
+	ev := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", pod, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  namespace,
+			Name:       pod,
+			UID:        types.UID(podID),
+		},
+		Reason:  reason,
+		Message: message,
+		Source: corev1.EventSource{
+			Component: r.component,
+			Host:      r.host,
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	select {
+	case r.queue <- ev:
+	default:
+		log.Warnf("event queue is full, drop event %s for pod %s/%s: %s", reason, namespace, pod, message)
+	}
+}
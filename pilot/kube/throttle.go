@@ -0,0 +1,27 @@
+package kube
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotationThrottlePrefix prefixes throttle options of all logs of the pod,
+// e.g. logging.caicloud.io/rate_lines: "100". Options are the same as the
+// env ones, see discovery.parseThrottlePolicy.
+const annotationThrottlePrefix = "logging.caicloud.io/"
+
+var throttleOptions = []string{"rate_lines", "rate_lines_burst", "rate_bytes", "rate_bytes_burst", "sampling"}
+
+func extractThrottleOptions(pod *corev1.Pod) map[string]string {
+	ret := make(map[string]string)
+	if pod == nil {
+		return ret
+	}
+	for _, opt := range throttleOptions {
+		if v := strings.TrimSpace(pod.Annotations[annotationThrottlePrefix+opt]); v != "" {
+			ret[opt] = v
+		}
+	}
+	return ret
+}
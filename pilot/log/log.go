@@ -1,10 +1,13 @@
 package log
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/elastic/beats/libbeat/logp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
@@ -16,6 +19,18 @@ var (
 		logp.CriticalLevel.String(): logp.CriticalLevel,
 	}
 
+	zapLevels = map[logp.Level]zapcore.Level{
+		logp.DebugLevel:    zapcore.DebugLevel,
+		logp.InfoLevel:     zapcore.InfoLevel,
+		logp.WarnLevel:     zapcore.WarnLevel,
+		logp.ErrorLevel:    zapcore.ErrorLevel,
+		logp.CriticalLevel: zapcore.ErrorLevel,
+	}
+
+	// level filters loggers created by NewLogger, it can be changed at
+	// runtime by SetLevel.
+	level = zap.NewAtomicLevel()
+
 	// DefaultLogger provides global logging motheds
 	DefaultLogger Logger
 )
@@ -29,7 +44,7 @@ func parseLevel(l string) logp.Level {
 }
 
 // Config initialize logp package
-func Config(level, logPath string, toStderr bool, maxSize, maxBackups uint) {
+func Config(lv, logPath string, toStderr bool, maxSize, maxBackups uint) {
 	wd, err := os.Getwd()
 	if err != nil {
 		panic(err)
@@ -37,9 +52,10 @@ func Config(level, logPath string, toStderr bool, maxSize, maxBackups uint) {
 	if logPath == "" {
 		logPath = filepath.Join(wd, "logs")
 	}
-	l := parseLevel(level)
+	level.SetLevel(zapLevels[parseLevel(lv)])
 	config := logp.DefaultConfig()
-	config.Level = l
+	// Levels are filtered by NewLogger, so they can be changed at runtime.
+	config.Level = logp.DebugLevel
 	config.ToStderr = toStderr
 	config.Files.Name = "log-pilot.log"
 	config.Files.Path = logPath
@@ -49,7 +65,45 @@ func Config(level, logPath string, toStderr bool, maxSize, maxBackups uint) {
 		panic(err)
 	}
 
-	DefaultLogger = logp.NewLogger("log-pilot")
+	DefaultLogger = NewLogger("log-pilot")
+}
+
+// NewLogger returns a logger labeled with the selector, whose level is set
+// by Config and SetLevel.
+func NewLogger(selector string) Logger {
+	return logp.NewLogger(selector, zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &levelCore{Core: c}
+	}))
+}
+
+// SetLevel changes level of loggers created by NewLogger.
+func SetLevel(lv string) error {
+	l, ok := levelMap[lv]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", lv)
+	}
+	level.SetLevel(zapLevels[l])
+	return nil
+}
+
+// levelCore drops entries below level.
+type levelCore struct {
+	zapcore.Core
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return level.Enabled(l) && c.Core.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields)}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !level.Enabled(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
 }
 
 // Fatal calls the same method of DefaultLogger
@@ -0,0 +1,31 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+func TestSetLevel(t *testing.T) {
+	if err := logp.DevelopmentSetup(logp.ToObserverOutput()); err != nil {
+		t.Fatal(err)
+	}
+	logger := NewLogger("test")
+	if err := SetLevel("warning"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept")
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("kept")
+
+	logs := logp.ObserverLogs().TakeAll()
+	if len(logs) != 2 || logs[0].Message != "kept" || logs[1].Message != "kept" {
+		t.Errorf("unexpected logs: %v", logs)
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("expect error of unknown level")
+	}
+}
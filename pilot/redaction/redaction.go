@@ -0,0 +1,158 @@
+package redaction
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule masks content matches Pattern with Replacement. Builtin refers to
+// a built-in rule, Pattern and Replacement are ignored if it's set.
+type Rule struct {
+	Name    string `yaml:"name"`
+	Builtin string `yaml:"builtin"`
+	// Pattern uses RE2 syntax, and is also evaluated by javascript, so
+	// inline flags like (?i) are not allowed, use IgnoreCase instead.
+	Pattern    string `yaml:"pattern"`
+	IgnoreCase bool   `yaml:"ignoreCase"`
+	// Replacement can refer capture groups by $1, $2...
+	Replacement string `yaml:"replacement"`
+}
+
+// RuleSet is a group of rules.
+type RuleSet struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Config is the content of redaction rules file, e.g.
+//
+//	rules:
+//	- builtin: credit_card
+//	- name: password
+//	  pattern: 'password=\S+'
+//	  replacement: 'password=***'
+//	namespaces:
+//	  payment:
+//	    rules:
+//	    - builtin: email
+//
+// Rules in namespaces are appended to the cluster level rules.
+type Config struct {
+	RuleSet    `yaml:",inline"`
+	Namespaces map[string]RuleSet `yaml:"namespaces"`
+}
+
+var builtins = map[string]Rule{
+	"credit_card": {
+		Pattern:     `\b(?:\d[ -]?){12,15}\d\b`,
+		Replacement: "****",
+	},
+	"bearer_token": {
+		Pattern:     `(bearer\s+)[A-Za-z0-9\-._~+/]+=*`,
+		IgnoreCase:  true,
+		Replacement: "$1****",
+	},
+	"email": {
+		Pattern:     `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+		Replacement: "****@****",
+	},
+}
+
+// inlineFlags matches go style inline flags which javascript doesn't support.
+var inlineFlags = regexp.MustCompile(`\(\?[imsU-]+[:)]`)
+
+// Rules contains validated redaction rules.
+type Rules struct {
+	cluster    []configurer.RedactionRule
+	namespaces map[string][]configurer.RedactionRule
+}
+
+// Load reads rules from file and validates them.
+func Load(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("error decode redaction rules: %v", err)
+	}
+	return New(cfg)
+}
+
+// New validates rules in cfg.
+func New(cfg *Config) (*Rules, error) {
+	cluster, err := compile(cfg.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster rules: %v", err)
+	}
+	ret := &Rules{
+		cluster:    cluster,
+		namespaces: make(map[string][]configurer.RedactionRule),
+	}
+	for ns, set := range cfg.Namespaces {
+		rules, err := compile(set.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rules of namespace %s: %v", ns, err)
+		}
+		ret.namespaces[ns] = rules
+	}
+	return ret, nil
+}
+
+// For returns rules apply to the namespace. It's safe to call on nil.
+func (r *Rules) For(namespace string) []configurer.RedactionRule {
+	if r == nil {
+		return nil
+	}
+	ret := make([]configurer.RedactionRule, 0, len(r.cluster)+len(r.namespaces[namespace]))
+	ret = append(ret, r.cluster...)
+	ret = append(ret, r.namespaces[namespace]...)
+	return ret
+}
+
+func compile(rules []Rule) ([]configurer.RedactionRule, error) {
+	var ret []configurer.RedactionRule
+	names := make(map[string]struct{})
+	for i, rule := range rules {
+		if rule.Builtin != "" {
+			b, ok := builtins[rule.Builtin]
+			if !ok {
+				return nil, fmt.Errorf("rule %d: unknown builtin %q", i, rule.Builtin)
+			}
+			if rule.Name == "" {
+				rule.Name = rule.Builtin
+			}
+			rule.Pattern, rule.IgnoreCase, rule.Replacement = b.Pattern, b.IgnoreCase, b.Replacement
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if _, dup := names[rule.Name]; dup {
+			return nil, fmt.Errorf("rule %d: duplicated name %s", i, rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %s: pattern is required", rule.Name)
+		}
+		if inlineFlags.MatchString(rule.Pattern) {
+			return nil, fmt.Errorf("rule %s: inline flags are not supported", rule.Name)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+
+		ret = append(ret, configurer.RedactionRule{
+			Name:        rule.Name,
+			Pattern:     rule.Pattern,
+			IgnoreCase:  rule.IgnoreCase,
+			Replacement: rule.Replacement,
+		})
+	}
+	return ret, nil
+}
@@ -0,0 +1,47 @@
+package redaction
+
+import (
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	cfg := &Config{
+		RuleSet: RuleSet{
+			Rules: []Rule{
+				{Builtin: "credit_card"},
+				{Name: "password", Pattern: `password=\S+`, Replacement: "password=***"},
+			},
+		},
+		Namespaces: map[string]RuleSet{
+			"payment": {Rules: []Rule{{Builtin: "email"}}},
+		},
+	}
+	rules, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rules.For("default")); n != 2 {
+		t.Errorf("expect 2 rules for default, got %d", n)
+	}
+	if n := len(rules.For("payment")); n != 3 {
+		t.Errorf("expect 3 rules for payment, got %d", n)
+	}
+
+	var nilRules *Rules
+	if n := len(nilRules.For("payment")); n != 0 {
+		t.Errorf("expect no rules, got %d", n)
+	}
+
+	invalid := [][]Rule{
+		{{Builtin: "unknown"}},
+		{{Pattern: "a"}},
+		{{Name: "a", Pattern: "("}},
+		{{Name: "a", Pattern: "(?i)a"}},
+		{{Name: "a", Pattern: "a"}, {Name: "a", Pattern: "b"}},
+	}
+	for i, rules := range invalid {
+		if _, err := New(&Config{RuleSet: RuleSet{Rules: rules}}); err == nil {
+			t.Errorf("case %d: expect error", i)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package routing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Route declares where the logs are sent to. Empty fields mean the default
+// output settings.
+type Route struct {
+	// Index is the suffix of elasticsearch index, logs are sent to
+	// logstash-<index>-<date>.
+	Index string `yaml:"index"`
+	// Topic is the kafka topic.
+	Topic string `yaml:"topic"`
+	// Pipeline is the elasticsearch ingest pipeline.
+	Pipeline string `yaml:"pipeline"`
+}
+
+// Config is the content of routing rules file, e.g.
+//
+//	namespaces:
+//	  payment:
+//	    index: payment
+//	    topic: logs-payment
+type Config struct {
+	Namespaces map[string]Route `yaml:"namespaces"`
+}
+
+var (
+	validIndex    = regexp.MustCompile(`^[a-z0-9][a-z0-9_\-]*$`)
+	validTopic    = regexp.MustCompile(`^[a-zA-Z0-9._\-]+$`)
+	validPipeline = regexp.MustCompile(`^[a-zA-Z0-9._\-]+$`)
+)
+
+// Validate checks the route can be used as index, topic and pipeline name.
+func (r Route) Validate() error {
+	if r.Index != "" && !validIndex.MatchString(r.Index) {
+		return fmt.Errorf("invalid index %q", r.Index)
+	}
+	if r.Topic != "" && !validTopic.MatchString(r.Topic) {
+		return fmt.Errorf("invalid topic %q", r.Topic)
+	}
+	if r.Pipeline != "" && !validPipeline.MatchString(r.Pipeline) {
+		return fmt.Errorf("invalid pipeline %q", r.Pipeline)
+	}
+	return nil
+}
+
+// Merge returns a copy of r whose fields are overridden by non-empty fields of o.
+func (r Route) Merge(o Route) Route {
+	if o.Index != "" {
+		r.Index = o.Index
+	}
+	if o.Topic != "" {
+		r.Topic = o.Topic
+	}
+	if o.Pipeline != "" {
+		r.Pipeline = o.Pipeline
+	}
+	return r
+}
+
+// OutOpts converts the route to configurer.LogConfig.OutOpts.
+func (r Route) OutOpts() map[string]string {
+	ret := make(map[string]string)
+	if r.Index != "" {
+		ret[configurer.OutOptIndex] = r.Index
+	}
+	if r.Topic != "" {
+		ret[configurer.OutOptTopic] = r.Topic
+	}
+	if r.Pipeline != "" {
+		ret[configurer.OutOptPipeline] = r.Pipeline
+	}
+	return ret
+}
+
+// Rules contains validated routes of namespaces.
+type Rules struct {
+	namespaces map[string]Route
+}
+
+// Load reads routing rules from file and validates them.
+func Load(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("error decode routing rules: %v", err)
+	}
+	return New(cfg)
+}
+
+// New validates routes in cfg.
+func New(cfg *Config) (*Rules, error) {
+	for ns, route := range cfg.Namespaces {
+		if err := route.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid route of namespace %s: %v", ns, err)
+		}
+	}
+	return &Rules{namespaces: cfg.Namespaces}, nil
+}
+
+// For returns route of the namespace. It's safe to call on nil.
+func (r *Rules) For(namespace string) Route {
+	if r == nil {
+		return Route{}
+	}
+	return r.namespaces[namespace]
+}
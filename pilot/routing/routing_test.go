@@ -0,0 +1,36 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+)
+
+func TestRoute(t *testing.T) {
+	rules, err := New(&Config{
+		Namespaces: map[string]Route{
+			"payment": {Index: "payment", Topic: "logs-payment"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := rules.For("payment").Merge(Route{Topic: "audit"})
+	opts := route.OutOpts()
+	if opts[configurer.OutOptIndex] != "payment" || opts[configurer.OutOptTopic] != "audit" {
+		t.Errorf("unexpected out options: %v", opts)
+	}
+	if _, ok := opts[configurer.OutOptPipeline]; ok {
+		t.Errorf("expect no pipeline, got %v", opts)
+	}
+
+	var nilRules *Rules
+	if len(nilRules.For("payment").OutOpts()) != 0 {
+		t.Error("expect empty route")
+	}
+
+	if _, err := New(&Config{Namespaces: map[string]Route{"a": {Index: "Upper"}}}); err == nil {
+		t.Error("expect error for invalid index")
+	}
+}
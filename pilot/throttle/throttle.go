@@ -0,0 +1,151 @@
+package throttle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/log-pilot/pilot/configurer"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Policy limits how many logs a source can send. Zero values mean no limit.
+type Policy struct {
+	LinesPerSecond int `yaml:"linesPerSecond"`
+	// LinesBurst defaults to LinesPerSecond.
+	LinesBurst     int `yaml:"linesBurst"`
+	BytesPerSecond int `yaml:"bytesPerSecond"`
+	// BytesBurst defaults to BytesPerSecond.
+	BytesBurst int `yaml:"bytesBurst"`
+	// Sampling keeps lines of a level by ratio, e.g. {"debug": 0.1}
+	// keeps 10% of debug lines. "*" matches all levels.
+	Sampling map[string]float64 `yaml:"sampling"`
+}
+
+// Config is the content of throttle policy file, e.g.
+//
+//	namespaces:
+//	  dev:
+//	    linesPerSecond: 1000
+//	    sampling:
+//	      debug: 0.1
+type Config struct {
+	Namespaces map[string]Policy `yaml:"namespaces"`
+}
+
+// Validate checks limits and sampling ratios.
+func (p Policy) Validate() error {
+	if p.LinesPerSecond < 0 || p.LinesBurst < 0 || p.BytesPerSecond < 0 || p.BytesBurst < 0 {
+		return fmt.Errorf("rate limits can not be negative")
+	}
+	for level, ratio := range p.Sampling {
+		if level != "*" && !configurer.ValidLogLevel(level) {
+			return fmt.Errorf("invalid sampling level %q", level)
+		}
+		if ratio < 0 || ratio > 1 {
+			return fmt.Errorf("sampling ratio of %s should be in [0, 1]", level)
+		}
+	}
+	return nil
+}
+
+// Merge returns a copy of p whose fields are overridden by non-zero fields of o.
+func (p Policy) Merge(o Policy) Policy {
+	if o.LinesPerSecond != 0 {
+		p.LinesPerSecond = o.LinesPerSecond
+	}
+	if o.LinesBurst != 0 {
+		p.LinesBurst = o.LinesBurst
+	}
+	if o.BytesPerSecond != 0 {
+		p.BytesPerSecond = o.BytesPerSecond
+	}
+	if o.BytesBurst != 0 {
+		p.BytesBurst = o.BytesBurst
+	}
+	if len(o.Sampling) > 0 {
+		p.Sampling = o.Sampling
+	}
+	return p
+}
+
+// Options converts the policy to configurer.ThrottleOptions, it returns
+// nil if nothing is limited.
+func (p Policy) Options() *configurer.ThrottleOptions {
+	if p.LinesPerSecond == 0 && p.BytesPerSecond == 0 && len(p.Sampling) == 0 {
+		return nil
+	}
+	ret := &configurer.ThrottleOptions{
+		LinesPerSecond: p.LinesPerSecond,
+		LinesBurst:     p.LinesBurst,
+		BytesPerSecond: p.BytesPerSecond,
+		BytesBurst:     p.BytesBurst,
+		Sampling:       p.Sampling,
+	}
+	if ret.LinesBurst < ret.LinesPerSecond {
+		ret.LinesBurst = ret.LinesPerSecond
+	}
+	if ret.BytesBurst < ret.BytesPerSecond {
+		ret.BytesBurst = ret.BytesPerSecond
+	}
+	return ret
+}
+
+// ParseSampling parses sampling like "debug:0.1,info:0.5,*:1".
+func ParseSampling(s string) (map[string]float64, error) {
+	ret := make(map[string]float64)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid sampling %q, expect <level>:<ratio>", item)
+		}
+		ratio, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sampling ratio of %s: %v", kv[0], err)
+		}
+		ret[kv[0]] = ratio
+	}
+	return ret, nil
+}
+
+// Rules contains validated policies of namespaces.
+type Rules struct {
+	namespaces map[string]Policy
+}
+
+// Load reads policies from file and validates them.
+func Load(path string) (*Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("error decode throttle policies: %v", err)
+	}
+	return New(cfg)
+}
+
+// New validates policies in cfg.
+func New(cfg *Config) (*Rules, error) {
+	for ns, p := range cfg.Namespaces {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid policy of namespace %s: %v", ns, err)
+		}
+	}
+	return &Rules{namespaces: cfg.Namespaces}, nil
+}
+
+// For returns policy of the namespace. It's safe to call on nil.
+func (r *Rules) For(namespace string) Policy {
+	if r == nil {
+		return Policy{}
+	}
+	return r.namespaces[namespace]
+}
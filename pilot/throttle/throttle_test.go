@@ -0,0 +1,45 @@
+package throttle
+
+import (
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	sampling, err := ParseSampling("debug:0.1, *:0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampling["debug"] != 0.1 || sampling["*"] != 0.5 {
+		t.Errorf("unexpected sampling: %v", sampling)
+	}
+	if _, err := ParseSampling("debug"); err == nil {
+		t.Error("expect error for missing ratio")
+	}
+
+	rules, err := New(&Config{
+		Namespaces: map[string]Policy{
+			"dev": {LinesPerSecond: 100, Sampling: map[string]float64{"debug": 0.1}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := rules.For("dev").Merge(Policy{BytesPerSecond: 1024}).Options()
+	if opts.LinesPerSecond != 100 || opts.LinesBurst != 100 || opts.BytesBurst != 1024 {
+		t.Errorf("unexpected options: %#v", opts)
+	}
+	if rules.For("prod").Options() != nil {
+		t.Error("expect no throttle for prod")
+	}
+
+	invalid := []Policy{
+		{LinesPerSecond: -1},
+		{Sampling: map[string]float64{"verbose": 0.1}},
+		{Sampling: map[string]float64{"debug": 2}},
+	}
+	for i, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("case %d: expect error", i)
+		}
+	}
+}